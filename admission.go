@@ -0,0 +1,177 @@
+package gws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+)
+
+// 连接准入控制, 限制总连接数, 单个 IP 的连接数和单个 IP 每秒的握手次数
+// Connection admission control, limits the total number of connections,
+// the number of connections per IP and the number of handshakes per IP per second
+type admission struct {
+	maxConnections      int64
+	maxConnectionsPerIP int
+	handshakeRatePerIP  int
+	metrics             *metrics
+
+	mu    sync.Mutex
+	conns map[string]int
+
+	// 握手次数按秒统计, 进入新的一秒时清空
+	// Handshakes are counted per second and cleared when a new second starts
+	second     int64
+	handshakes map[string]int
+}
+
+// 连接升级后被拒绝, 响应已经写入, 不需要再写入错误响应
+// Rejected after the upgrade, the response has been written and no error response is needed
+type upgradedError struct{ err error }
+
+func (c *upgradedError) Error() string { return c.err.Error() }
+
+func (c *upgradedError) Unwrap() error { return c.err }
+
+func newAdmission(option *ServerOption) *admission {
+	return &admission{
+		maxConnections:      int64(option.MaxConnections),
+		maxConnectionsPerIP: option.MaxConnectionsPerIP,
+		handshakeRatePerIP:  option.HandshakeRatePerIP,
+		metrics:             option.config.metrics,
+		conns:               make(map[string]int),
+		handshakes:          make(map[string]int),
+	}
+}
+
+// 获取准入名额, 超出限制时返回错误
+// Acquires an admission slot, returns an error if a limit is exceeded
+func (c *admission) acquire(ip string) error {
+	if c.maxConnectionsPerIP > 0 || c.handshakeRatePerIP > 0 {
+		if err := c.acquireIP(ip); err != nil {
+			return err
+		}
+	}
+	if n := atomic.AddInt64(&c.metrics.activeConnections, 1); c.maxConnections > 0 && n > c.maxConnections {
+		atomic.AddInt64(&c.metrics.activeConnections, -1)
+		atomic.AddUint64(&c.metrics.connectionLimitExceeded, 1)
+		c.releaseIP(ip)
+		return ErrConnectionLimit
+	}
+	return nil
+}
+
+func (c *admission) acquireIP(ip string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.handshakeRatePerIP > 0 {
+		if now := time.Now().Unix(); now != c.second {
+			c.second, c.handshakes = now, make(map[string]int)
+		}
+		if c.handshakes[ip]++; c.handshakes[ip] > c.handshakeRatePerIP {
+			atomic.AddUint64(&c.metrics.handshakeRateExceeded, 1)
+			return ErrHandshakeRate
+		}
+	}
+	if c.maxConnectionsPerIP > 0 {
+		if c.conns[ip] >= c.maxConnectionsPerIP {
+			atomic.AddUint64(&c.metrics.connectionLimitPerIPExceeded, 1)
+			return ErrConnectionLimitPerIP
+		}
+		c.conns[ip]++
+	}
+	return nil
+}
+
+// 释放准入名额
+// Releases the admission slot
+func (c *admission) release(ip string) {
+	atomic.AddInt64(&c.metrics.activeConnections, -1)
+	c.releaseIP(ip)
+}
+
+func (c *admission) releaseIP(ip string) {
+	if c.maxConnectionsPerIP <= 0 {
+		return
+	}
+	c.mu.Lock()
+	if c.conns[ip]--; c.conns[ip] <= 0 {
+		delete(c.conns, ip)
+	}
+	c.mu.Unlock()
+}
+
+// 获取地址中的主机部分
+// Gets the host part of the address
+func remoteHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// 准入拒绝在升级前以 503 响应
+// Admission rejections are answered with 503 before the upgrade
+func newAdmissionError(err error) *HandshakeError {
+	var e = &HandshakeError{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Err: err}
+	e.Header.Set("Retry-After", "1")
+	return e
+}
+
+// 服务端发送的关闭帧, 不需要掩码
+// Close frame sent by the server, no mask is needed
+func newCloseFrame(code internal.StatusCode, reason string) []byte {
+	if len(reason) > internal.ThresholdV1-2 {
+		reason = reason[:internal.ThresholdV1-2]
+	}
+	var frame = []byte{0x80 | byte(OpcodeCloseConnection), byte(2 + len(reason))}
+	frame = append(frame, code.Bytes()...)
+	return append(frame, reason...)
+}
+
+// 准入被拒绝, 根据配置在升级前返回 503, 或者完成升级后以 1013 关闭连接
+// Admission is rejected, depending on the configuration 503 is returned before the upgrade,
+// or the connection is closed with 1013 after the upgrade
+func (c *Upgrader) rejectFromConn(netConn net.Conn, r *http.Request, err error) error {
+	var websocketKey = r.Header.Get(internal.SecWebSocketKey.Key)
+	if !c.option.RejectAfterUpgrade || websocketKey == "" {
+		return newAdmissionError(err)
+	}
+	var rw = new(responseWriter).Init()
+	defer rw.Close()
+	rw.WithHeader(internal.SecWebSocketAccept.Key, internal.ComputeAcceptKey(websocketKey))
+	rw.b.WriteString("\r\n")
+	rw.b.Write(newCloseFrame(internal.CloseTryAgainLater, err.Error()))
+	_ = netConn.SetDeadline(time.Now().Add(c.option.HandshakeTimeout))
+	_, _ = rw.b.WriteTo(netConn)
+	_ = netConn.Close()
+	return &upgradedError{err: err}
+}
+
+// HTTP/2 流的准入拒绝
+// Admission rejection of an HTTP/2 stream
+func (c *Upgrader) rejectHTTP2(w http.ResponseWriter, err error) error {
+	if !c.option.RejectAfterUpgrade {
+		return newAdmissionError(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(newCloseFrame(internal.CloseTryAgainLater, err.Error()))
+	_ = http.NewResponseController(w).Flush()
+	return &upgradedError{err: err}
+}
+
+// 是否已经在升级后拒绝
+// Whether it has been rejected after the upgrade
+func isUpgradedError(err error) bool {
+	var e *upgradedError
+	return errors.As(err, &e)
+}
@@ -0,0 +1,118 @@
+package gws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission(t *testing.T) {
+	var as = assert.New(t)
+
+	var run = func(option *ServerOption) (*Server, string) {
+		var addr = "127.0.0.1:" + nextPort()
+		var server = NewServer(new(BuiltinEventHandler), option)
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+		return server, "ws://" + addr
+	}
+
+	var dial = func(addr string, handler Event) (*Conn, *http.Response, error) {
+		client, resp, err := NewClient(handler, &ClientOption{Addr: addr})
+		if err == nil {
+			go client.ReadLoop()
+		}
+		return client, resp, err
+	}
+
+	t.Run("max connections", func(t *testing.T) {
+		server, addr := run(&ServerOption{MaxConnections: 2})
+		client1, _, err := dial(addr, new(BuiltinEventHandler))
+		as.NoError(err)
+		_, _, err = dial(addr, new(BuiltinEventHandler))
+		as.NoError(err)
+
+		_, resp, err := dial(addr, new(BuiltinEventHandler))
+		as.Error(err)
+		as.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+		as.Equal("1", resp.Header.Get("Retry-After"))
+		as.Equal(int64(2), server.Metrics().ActiveConnections)
+		as.Equal(uint64(1), server.Metrics().ConnectionLimitExceeded)
+
+		// 连接关闭后释放名额
+		// The slot is released after the connection is closed
+		_ = client1.NetConn().Close()
+		time.Sleep(100 * time.Millisecond)
+		as.Equal(int64(1), server.Metrics().ActiveConnections)
+		_, _, err = dial(addr, new(BuiltinEventHandler))
+		as.NoError(err)
+	})
+
+	t.Run("max connections per ip", func(t *testing.T) {
+		server, addr := run(&ServerOption{MaxConnectionsPerIP: 1})
+		_, _, err := dial(addr, new(BuiltinEventHandler))
+		as.NoError(err)
+		_, resp, err := dial(addr, new(BuiltinEventHandler))
+		as.Error(err)
+		as.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+		as.Equal(uint64(1), server.Metrics().ConnectionLimitPerIPExceeded)
+	})
+
+	t.Run("handshake rate", func(t *testing.T) {
+		server, addr := run(&ServerOption{HandshakeRatePerIP: 2})
+		var rejected = 0
+		for i := 0; i < 5; i++ {
+			if _, _, err := dial(addr, new(BuiltinEventHandler)); err != nil {
+				rejected++
+			}
+		}
+		as.GreaterOrEqual(rejected, 1)
+		as.Equal(uint64(rejected), server.Metrics().HandshakeRateExceeded)
+	})
+
+	t.Run("reject after upgrade", func(t *testing.T) {
+		_, addr := run(&ServerOption{MaxConnections: 1, RejectAfterUpgrade: true})
+		_, _, err := dial(addr, new(BuiltinEventHandler))
+		as.NoError(err)
+
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		var handler = new(webSocketMocker)
+		handler.onClose = func(socket *Conn, err error) {
+			var closeErr *CloseError
+			if as.True(errors.As(err, &closeErr)) {
+				as.Equal(internal.CloseTryAgainLater.Uint16(), closeErr.Code)
+			}
+			wg.Done()
+		}
+		_, resp, err := dial(addr, handler)
+		if as.NoError(err) {
+			as.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+			wg.Wait()
+		}
+	})
+
+	t.Run("admission", func(t *testing.T) {
+		var option = initServerOption(&ServerOption{MaxConnections: 2, MaxConnectionsPerIP: 1, HandshakeRatePerIP: 10})
+		var a = newAdmission(option)
+		as.NoError(a.acquire("a"))
+		as.ErrorIs(a.acquire("a"), ErrConnectionLimitPerIP)
+		as.NoError(a.acquire("b"))
+		as.ErrorIs(a.acquire("c"), ErrConnectionLimit)
+		as.Equal(0, a.conns["c"])
+		a.release("a")
+		as.NoError(a.acquire("c"))
+		as.Equal(2, len(a.conns))
+
+		as.Equal("127.0.0.1", remoteHost(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}))
+		as.Equal("pipe", remoteHost(http2Addr("pipe")))
+		as.Equal("", remoteHost(nil))
+		as.Equal(2+internal.ThresholdV1, len(newCloseFrame(internal.CloseTryAgainLater, string(make([]byte, 200)))))
+	})
+}
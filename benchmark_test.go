@@ -46,11 +46,11 @@ func BenchmarkConn_WriteMessage(b *testing.B) {
 		})
 		var config = upgrader.option.getConfig()
 		var conn = &Conn{
-			conn:     &benchConn{},
-			pd:       PermessageDeflate{Enabled: true},
-			config:   config,
-			deflater: upgrader.deflaterPool.Select(),
+			conn:   &benchConn{},
+			config: config,
 		}
+		_, extensions := negotiateExtensions(internal.PermessageDeflate, upgrader.extensions)
+		conn.setExtensions(extensions)
 		for i := 0; i < b.N; i++ {
 			_ = conn.WriteMessage(OpcodeText, githubData)
 		}
@@ -98,11 +98,11 @@ func BenchmarkConn_ReadMessage(b *testing.B) {
 		var conn1 = &Conn{
 			isServer: false,
 			conn:     &benchConn{},
-			pd:       upgrader.option.PermessageDeflate,
 			config:   config,
-			deflater: new(deflater),
 		}
-		conn1.deflater.initialize(false, conn1.pd, config.ReadMaxPayloadSize)
+		var clientExtension = newPermessageDeflateExtension(false, upgrader.option.PermessageDeflate, nil, config.ReadMaxPayloadSize)
+		clientExtensions, _ := confirmExtensions(internal.SecWebSocketExtensions.Val, []Extension{clientExtension})
+		conn1.setExtensions(clientExtensions)
 		var buf, _ = conn1.genFrame(OpcodeText, internal.Bytes(githubData), frameConfig{
 			fin:           true,
 			compress:      conn1.pd.Enabled,
@@ -116,10 +116,10 @@ func BenchmarkConn_ReadMessage(b *testing.B) {
 			conn:     &benchConn{},
 			br:       bufio.NewReader(reader),
 			config:   upgrader.option.getConfig(),
-			pd:       upgrader.option.PermessageDeflate,
 			handler:  upgrader.eventHandler,
-			deflater: upgrader.deflaterPool.Select(),
 		}
+		_, serverExtensions := negotiateExtensions(internal.PermessageDeflate, upgrader.extensions)
+		conn2.setExtensions(serverExtensions)
 		for i := 0; i < b.N; i++ {
 			internal.BufferReset(reader, buf.Bytes())
 			conn2.br.Reset(reader)
@@ -24,33 +24,130 @@ type Dialer interface {
 	Dial(network, addr string) (c net.Conn, err error)
 }
 
+// ContextDialer 支持上下文的拨号器接口, 例如 *net.Dialer. 拨号器实现了该接口时, 取消上下文会中断 DNS 解析和 TCP 连接.
+// Dialer interface with context support, such as *net.Dialer. If the dialer implements this interface,
+// canceling the context interrupts DNS resolution and the TCP connection.
+type ContextDialer interface {
+	// DialContext 使用上下文连接到指定网络上的地址
+	// Connects to the address on the named network using the context
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// 过去的时间, 设置为截止时间可以立即中断阻塞的读写
+// A time in the past, setting it as the deadline interrupts blocked reads and writes immediately
+var aLongTimeAgo = time.Unix(1, 0)
+
 type connector struct {
+	ctx             context.Context
 	option          *ClientOption
 	conn            net.Conn
 	eventHandler    Event
 	secWebsocketKey string
+
+	// 参与协商的全部拓展
+	// All extensions participating in the negotiation
+	extensions []Extension
+
+	// 握手请求的 URL, ws+unix 连接使用套接字路径之后的请求路径
+	// URL of the handshake request, ws+unix connections use the request path after the socket path
+	target string
+
+	// 是否根据连接地址设置 TLS 服务器名称
+	// Whether the TLS server name is set according to the connection address
+	autoServerName bool
+
+	// 是否已经重定向到其他主机, 此时不再发送 RequestHeader 中的认证信息, Cookie 和 Host
+	// Whether it has been redirected to another host, in which case the credentials, cookies and Host
+	// in RequestHeader are no longer sent
+	crossHost bool
 }
 
 // NewClient 创建一个新的 WebSocket 客户端连接
+// 除了 ws 和 wss, 还支持通过 Unix 域套接字连接的 ws+unix, 例如 ws+unix:///tmp/app.sock:/connect
 // Creates a new WebSocket client connection
+// Besides ws and wss, ws+unix is supported for connections over Unix domain sockets, e.g. ws+unix:///tmp/app.sock:/connect
 func NewClient(handler Event, option *ClientOption) (*Conn, *http.Response, error) {
+	return NewClientContext(context.Background(), handler, option)
+}
+
+// NewClientContext 使用上下文创建 WebSocket 客户端连接, 取消上下文会中断 DNS 解析, TCP 连接, TLS 握手和 HTTP 握手.
+// 上下文只作用于建立连接的过程, 连接建立后取消上下文不会关闭连接.
+// Creates a WebSocket client connection using the context, canceling the context interrupts DNS resolution,
+// the TCP connection, the TLS handshake and the HTTP handshake.
+// The context only applies to establishing the connection, canceling it afterwards does not close the connection.
+func NewClientContext(ctx context.Context, handler Event, option *ClientOption) (*Conn, *http.Response, error) {
 	option = initClientOption(option)
-	c := &connector{option: option, eventHandler: handler}
+	c := &connector{
+		ctx:            ctx,
+		option:         option,
+		eventHandler:   handler,
+		extensions:     option.newExtensions(),
+		target:         option.Addr,
+		autoServerName: option.TlsConfig == nil || option.TlsConfig.ServerName == "",
+	}
 	URL, err := url.Parse(option.Addr)
 	if err != nil {
 		return nil, nil, err
 	}
-	if URL.Scheme != "ws" && URL.Scheme != "wss" {
+	if URL.Scheme != "ws" && URL.Scheme != "wss" && URL.Scheme != schemeUnix {
 		return nil, nil, ErrUnsupportedProtocol
 	}
+	if option.HTTP2Transport != nil {
+		if URL.Scheme == schemeUnix {
+			return nil, nil, ErrUnsupportedProtocol
+		}
+		return c.handshakeHTTP2(URL)
+	}
 
+	for redirects := 0; ; redirects++ {
+		client, resp, err := c.dial(URL)
+		if err == nil || !c.shouldRedirect(URL, resp) {
+			return client, resp, err
+		}
+		if redirects >= option.MaxRedirects {
+			return nil, resp, ErrTooManyRedirects
+		}
+		if URL, err = c.redirect(URL, resp); err != nil {
+			return nil, resp, err
+		}
+	}
+}
+
+// 拨号并执行 TLS 和 WebSocket 握手, 失败时关闭连接
+// Dials and performs the TLS and WebSocket handshakes, the connection is closed on failure
+func (c *connector) dial(URL *url.URL) (*Conn, *http.Response, error) {
+	var option = c.option
 	var tlsEnabled = URL.Scheme == "wss"
+	var network, addr = "tcp", internal.GetAddrFromURL(URL, tlsEnabled)
+	var err error
+	if URL.Scheme == schemeUnix {
+		if addr, c.target, err = parseUnixURL(URL); err != nil {
+			return nil, nil, err
+		}
+		network = networkUnix
+	}
+
+	var proxyURL *url.URL
+	if network == "tcp" {
+		if proxyURL, err = c.proxyURL(URL); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	dialer, err := option.NewDialer()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	c.conn, err = dialer.Dial("tcp", internal.GetAddrFromURL(URL, tlsEnabled))
+	// 使用代理时, 拨号超时时间包括连接代理服务器和建立隧道
+	// When using a proxy, the dial timeout includes connecting to the proxy server and establishing the tunnel
+	dialCtx, cancel := context.WithTimeout(c.ctx, option.DialTimeout)
+	if proxyURL != nil {
+		c.conn, err = dialProxy(dialCtx, dialer, proxyURL, addr)
+	} else {
+		c.conn, err = dialContext(dialCtx, dialer, network, addr)
+	}
+	cancel()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -58,10 +155,24 @@ func NewClient(handler Event, option *ClientOption) (*Conn, *http.Response, erro
 		if option.TlsConfig == nil {
 			option.TlsConfig = &tls.Config{}
 		}
-		if option.TlsConfig.ServerName == "" {
-			option.TlsConfig.ServerName = URL.Hostname()
+		var config = option.TlsConfig
+		if c.autoServerName && config.ServerName != URL.Hostname() {
+			// 重定向到其他主机时, 复制配置以免修改第一次连接使用的服务器名称
+			// When redirected to another host, the config is copied to keep the server name used by the first connection
+			if config.ServerName != "" {
+				config = config.Clone()
+			}
+			config.ServerName = URL.Hostname()
+		}
+		var tlsConn = tls.Client(c.conn, config)
+		c.conn = tlsConn
+		tlsCtx, cancel := context.WithTimeout(c.ctx, option.TlsHandshakeTimeout)
+		err = tlsConn.HandshakeContext(tlsCtx)
+		cancel()
+		if err != nil {
+			_ = c.conn.Close()
+			return nil, nil, err
 		}
-		c.conn = tls.Client(c.conn, option.TlsConfig)
 	}
 
 	client, resp, err := c.handshake()
@@ -71,11 +182,80 @@ func NewClient(handler Event, option *ClientOption) (*Conn, *http.Response, erro
 	return client, resp, err
 }
 
+// 使用上下文拨号. 拨号器没有实现 ContextDialer 时, 上下文结束后不再等待拨号结果, 稍后建立的连接会被关闭.
+// Dials using the context. If the dialer does not implement ContextDialer, the dial result is no longer waited for
+// after the context is done, and a connection established later is closed.
+func dialContext(ctx context.Context, dialer Dialer, network, addr string) (net.Conn, error) {
+	if d, ok := dialer.(ContextDialer); ok {
+		return d.DialContext(ctx, network, addr)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	var ch = make(chan result, 1)
+	go func() {
+		conn, err := dialer.Dial(network, addr)
+		ch <- result{conn: conn, err: err}
+	}()
+	select {
+	case v := <-ch:
+		return v.conn, v.err
+	case <-ctx.Done():
+		go func() {
+			if v := <-ch; v.conn != nil {
+				_ = v.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// 上下文结束时在新的 goroutine 中调用 f, 与 context.AfterFunc 类似.
+// stop 等待 goroutine 退出, f 已被调用时返回 false. 上下文不会结束时不启动 goroutine.
+// Calls f in a new goroutine when the context is done, similar to context.AfterFunc.
+// stop waits for the goroutine to exit, returns false if f has been called.
+// No goroutine is started if the context is never done.
+func afterFunc(ctx context.Context, f func()) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return true }
+	}
+	var quit = make(chan struct{})
+	var stopped = make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f()
+			stopped <- false
+		case <-quit:
+			stopped <- true
+		}
+	}()
+	return func() bool {
+		close(quit)
+		return <-stopped
+	}
+}
+
 // NewClientFromConn 通过外部连接创建客户端, 支持 TCP/KCP/Unix Domain Socket
 // Create new client via external connection, supports TCP/KCP/Unix Domain Socket.
 func NewClientFromConn(handler Event, option *ClientOption, conn net.Conn) (*Conn, *http.Response, error) {
 	option = initClientOption(option)
-	c := &connector{option: option, conn: conn, eventHandler: handler}
+	c := &connector{
+		ctx:          context.Background(),
+		option:       option,
+		conn:         wrapPacketConn(conn),
+		eventHandler: handler,
+		extensions:   option.newExtensions(),
+		target:       option.Addr,
+	}
+	if URL, err := url.Parse(option.Addr); err == nil && URL.Scheme == schemeUnix {
+		if _, c.target, err = parseUnixURL(URL); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
 	client, resp, err := c.handshake()
 	if err != nil {
 		_ = c.conn.Close()
@@ -83,31 +263,61 @@ func NewClientFromConn(handler Event, option *ClientOption, conn net.Conn) (*Con
 	return client, resp, err
 }
 
-// 发送HTTP请求, 即WebSocket握手
-// Sends an http request, i.e., websocket handshake
+// 设置 HTTP/1.1 和 HTTP/2 握手共用的请求头
+// Sets the request headers shared by HTTP/1.1 and HTTP/2 handshakes
+func (c *connector) setRequestHeader(r *http.Request) {
+	for k, v := range c.option.RequestHeader {
+		// 重定向到其他主机后不再覆盖 Host, 否则请求仍会发往原来的虚拟主机
+		// The Host is no longer overridden after being redirected to another host,
+		// otherwise the request would still go to the original virtual host
+		if c.crossHost && (sensitiveHeaders[http.CanonicalHeaderKey(k)] || http.CanonicalHeaderKey(k) == "Host") {
+			continue
+		}
+		if k == "Host" && len(v) > 0 {
+			r.Host = v[0]
+		}
+		r.Header[k] = v
+	}
+
+	// 地址中的用户名和密码作为 Basic 认证发送, RequestHeader 中已有 Authorization 时不覆盖
+	// The username and password in the address are sent as Basic authentication,
+	// an existing Authorization in RequestHeader is not overwritten
+	if user := r.URL.User; user != nil && r.Header.Get("Authorization") == "" {
+		var password, _ = user.Password()
+		r.SetBasicAuth(user.Username(), password)
+	}
+	if c.option.Jar != nil {
+		for _, cookie := range c.option.Jar.Cookies(cookieURL(r.URL)) {
+			r.AddCookie(cookie)
+		}
+	}
+	r.Header.Set(internal.SecWebSocketVersion.Key, internal.SecWebSocketVersion.Val)
+	if len(c.option.SubProtocols) > 0 {
+		r.Header.Set(internal.SecWebSocketProtocol.Key, strings.Join(c.option.offeredSubProtocols(), ", "))
+	}
+	if len(c.extensions) > 0 {
+		r.Header.Set(internal.SecWebSocketExtensions.Key, offerExtensions(c.extensions))
+	}
+}
+
+// 发送HTTP请求, 即WebSocket握手. 超时或者上下文取消时中断读写.
+// Sends an http request, i.e., websocket handshake. Reads and writes are interrupted on timeout or context cancellation.
 func (c *connector) request() (*http.Response, *bufio.Reader, error) {
-	_ = c.conn.SetDeadline(time.Now().Add(c.option.HandshakeTimeout))
-	ctx, cancel := context.WithTimeout(context.Background(), c.option.HandshakeTimeout)
+	if c.ctx == nil {
+		c.ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.option.HandshakeTimeout)
 	defer cancel()
 
 	// 构建HTTP请求
 	// building a http request
-	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.option.Addr, nil)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.target, nil)
 	if err != nil {
 		return nil, nil, err
 	}
-	for k, v := range c.option.RequestHeader {
-		if k == "Host" && len(v) > 0 {
-			r.Host = v[0]
-		}
-		r.Header[k] = v
-	}
+	c.setRequestHeader(r)
 	r.Header.Set(internal.Connection.Key, internal.Connection.Val)
 	r.Header.Set(internal.Upgrade.Key, internal.Upgrade.Val)
-	r.Header.Set(internal.SecWebSocketVersion.Key, internal.SecWebSocketVersion.Val)
-	if c.option.PermessageDeflate.Enabled {
-		r.Header.Set(internal.SecWebSocketExtensions.Key, c.option.PermessageDeflate.genRequestHeader())
-	}
 	if c.secWebsocketKey == "" {
 		var key [16]byte
 		binary.BigEndian.PutUint64(key[0:8], internal.AlphabetNumeric.Uint64())
@@ -116,47 +326,26 @@ func (c *connector) request() (*http.Response, *bufio.Reader, error) {
 		r.Header.Set(internal.SecWebSocketKey.Key, c.secWebsocketKey)
 	}
 
-	var ch = make(chan error)
-
-	// 发送http请求
-	// send http request
-	go func() { ch <- r.Write(c.conn) }()
+	// 截止时间处理超时, 上下文取消时将截止时间设置为过去的时间
+	// The deadline handles the timeout, it is set to a time in the past when the context is canceled
+	deadline, _ := ctx.Deadline()
+	_ = c.conn.SetDeadline(deadline)
+	var stop = afterFunc(c.ctx, func() { _ = c.conn.SetDeadline(aLongTimeAgo) })
 
-	// 同步等待请求是否发送成功
-	// Synchronized waiting for the request to be sent successfully
-	select {
-	case err = <-ch:
-	case <-ctx.Done():
-		err = ctx.Err()
+	// 发送http请求并读取响应结果
+	// Send the http request and read the response result
+	var resp *http.Response
+	var br = bufio.NewReaderSize(c.conn, c.option.ReadBufferSize)
+	if err = r.Write(c.conn); err == nil {
+		resp, err = http.ReadResponse(br, r)
 	}
-	if err != nil {
-		return nil, nil, err
+	if err == nil && c.option.Jar != nil {
+		c.option.Jar.SetCookies(cookieURL(r.URL), resp.Cookies())
 	}
-
-	// 读取响应结果
-	// Read the response result
-	br := bufio.NewReaderSize(c.conn, c.option.ReadBufferSize)
-	resp, err := http.ReadResponse(br, r)
-	return resp, br, err
-}
-
-// 获取压缩拓展结果
-// Get compression expansion results
-func (c *connector) getPermessageDeflate(extensions string) PermessageDeflate {
-	serverPD := permessageNegotiation(extensions)
-	clientPD := c.option.PermessageDeflate
-	pd := PermessageDeflate{
-		Enabled:               clientPD.Enabled && strings.Contains(extensions, internal.PermessageDeflate),
-		Threshold:             clientPD.Threshold,
-		Level:                 clientPD.Level,
-		PoolSize:              clientPD.PoolSize,
-		ServerContextTakeover: serverPD.ServerContextTakeover,
-		ClientContextTakeover: serverPD.ClientContextTakeover,
-		ServerMaxWindowBits:   serverPD.ServerMaxWindowBits,
-		ClientMaxWindowBits:   serverPD.ClientMaxWindowBits,
+	if !stop() || (err != nil && c.ctx.Err() != nil) {
+		return nil, nil, c.ctx.Err()
 	}
-	pd.setThreshold(false)
-	return pd
+	return resp, br, err
 }
 
 // 执行 WebSocket 握手操作
@@ -169,54 +358,65 @@ func (c *connector) handshake() (*Conn, *http.Response, error) {
 	if err = c.checkHeaders(resp); err != nil {
 		return nil, resp, err
 	}
-	subprotocol, err := c.getSubProtocol(resp)
+	subprotocol, extensions, err := c.confirm(resp)
 	if err != nil {
 		return nil, resp, err
 	}
+	socket := c.newConn(c.conn, br, subprotocol, extensions)
+	return socket, resp, c.conn.SetDeadline(time.Time{})
+}
 
-	var extensions = resp.Header.Get(internal.SecWebSocketExtensions.Key)
-	var pd = c.getPermessageDeflate(extensions)
+// 确认服务端选择的子协议和拓展
+// Confirms the subprotocol and extensions selected by the server
+func (c *connector) confirm(resp *http.Response) (string, []negotiatedExtension, error) {
+	subprotocol, err := c.getSubProtocol(resp)
+	if err != nil {
+		return "", nil, err
+	}
+	var responses = strings.Join(resp.Header.Values(internal.SecWebSocketExtensions.Key), ", ")
+	extensions, err := confirmExtensions(responses, c.extensions)
+	if err != nil {
+		return "", nil, err
+	}
+	return subprotocol, extensions, nil
+}
+
+// 创建客户端连接
+// Creates a client-side connection
+func (c *connector) newConn(conn net.Conn, br *bufio.Reader, subprotocol string, extensions []negotiatedExtension) *Conn {
 	socket := &Conn{
 		ss:                c.option.NewSession(),
 		isServer:          false,
 		subprotocol:       subprotocol,
-		pd:                pd,
-		conn:              c.conn,
+		conn:              conn,
 		config:            c.option.getConfig(),
 		br:                br,
 		continuationFrame: continuationFrame{},
 		fh:                frameHeader{},
-		handler:           c.eventHandler,
+		handler:           selectEventHandler(c.eventHandler, c.option.SubProtocolHandlers, subprotocol),
 		closed:            0,
-		deflater:          new(deflater),
 		writeQueue:        workerQueue{maxConcurrency: 1},
 		readQueue:         make(channel, c.option.ParallelGolimit),
 	}
-
-	// 压缩字典和解压字典内存开销比较大, 故使用懒加载
-	// Compressing and decompressing dictionaries has a large memory overhead, so use lazy loading.
-	if pd.Enabled {
-		socket.deflater.initialize(false, pd, c.option.ReadMaxPayloadSize)
-		if pd.ServerContextTakeover {
-			socket.dpsWindow.initialize(nil, pd.ServerMaxWindowBits)
-		}
-		if pd.ClientContextTakeover {
-			socket.cpsWindow.initialize(nil, pd.ClientMaxWindowBits)
-		}
-	}
-	return socket, resp, c.conn.SetDeadline(time.Time{})
+	socket.setExtensions(extensions)
+	return socket
 }
 
-// 从响应中获取子协议
-// Retrieves the subprotocol from the response
+// 从响应中获取子协议, 服务端最多只能选择一个客户端提议的子协议, 也可以不选择
+// Retrieves the subprotocol from the response, the server can select at most one of the subprotocols offered by the client, or none
 func (c *connector) getSubProtocol(resp *http.Response) (string, error) {
-	a := internal.Split(c.option.RequestHeader.Get(internal.SecWebSocketProtocol.Key), ",")
-	b := internal.Split(resp.Header.Get(internal.SecWebSocketProtocol.Key), ",")
-	subprotocol := internal.GetIntersectionElem(a, b)
-	if len(a) > 0 && subprotocol == "" {
+	a := c.option.offeredSubProtocols()
+	b := parseSubProtocols(resp.Header)
+	switch {
+	case len(b) > 1:
+		return "", ErrSubprotocolNegotiation
+	case len(b) == 1 && !internal.InCollection(b[0], a):
 		return "", ErrSubprotocolNegotiation
+	case len(b) == 1:
+		return b[0], nil
+	default:
+		return "", nil
 	}
-	return subprotocol, nil
 }
 
 // 检查响应头以验证握手是否成功
@@ -1,10 +1,12 @@
 package gws
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -257,6 +259,7 @@ func TestClientHandshakeFail(t *testing.T) {
 			conn:            cli,
 			secWebsocketKey: "1fTfP/qALD+eAWcU80P0bg==",
 			eventHandler:    new(BuiltinEventHandler),
+			extensions:      option.newExtensions(),
 		}
 
 		go func() {
@@ -422,3 +425,133 @@ func TestNewClient_WriteRequest(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+type slowDialer struct{ delay time.Duration }
+
+func (c *slowDialer) Dial(network, addr string) (net.Conn, error) {
+	time.Sleep(c.delay)
+	return net.Dial(network, addr)
+}
+
+func TestNewClientContext(t *testing.T) {
+	var as = assert.New(t)
+
+	// 接受连接但是不响应的服务器
+	// A server that accepts connections but never responds
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !as.NoError(err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	var silentAddr = listener.Addr().String()
+
+	t.Run("cancel handshake", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		var start = time.Now()
+		_, _, err := NewClientContext(ctx, new(BuiltinEventHandler), &ClientOption{
+			Addr:             "ws://" + silentAddr,
+			HandshakeTimeout: 5 * time.Second,
+		})
+		as.ErrorIs(err, context.DeadlineExceeded)
+		as.Less(time.Since(start), time.Second)
+	})
+
+	t.Run("tls handshake timeout", func(t *testing.T) {
+		var start = time.Now()
+		_, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:                "wss://" + silentAddr,
+			HandshakeTimeout:    5 * time.Second,
+			TlsHandshakeTimeout: 100 * time.Millisecond,
+		})
+		as.Error(err)
+		as.Less(time.Since(start), time.Second)
+	})
+
+	t.Run("cancel dial", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var start = time.Now()
+		_, _, err := NewClientContext(ctx, new(BuiltinEventHandler), &ClientOption{
+			Addr:      "ws://" + silentAddr,
+			NewDialer: func() (Dialer, error) { return &slowDialer{delay: time.Second}, nil },
+		})
+		as.ErrorIs(err, context.DeadlineExceeded)
+		as.Less(time.Since(start), 500*time.Millisecond)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, _, err = NewClientContext(ctx, new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + silentAddr})
+		as.ErrorIs(err, context.Canceled)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var handler = new(webSocketMocker)
+		handler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var server = NewServer(handler, nil)
+		var addr = "127.0.0.1:" + nextPort()
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var messages = make(chan string, 1)
+		client, _, err := NewClientContext(ctx, &webSocketMocker{onMessage: func(socket *Conn, message *Message) {
+			messages <- message.Data.String()
+		}}, &ClientOption{Addr: "ws://" + addr, DialTimeout: time.Second})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+
+		// 连接建立后取消上下文不会影响连接
+		// Canceling the context after the connection is established does not affect the connection
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		go client.ReadLoop()
+		as.NoError(client.WriteString("hello"))
+		as.Equal("hello", <-messages)
+	})
+}
+
+func TestNewClientSharedOption(t *testing.T) {
+	var as = assert.New(t)
+	var addr = "127.0.0.1:" + nextPort()
+	go NewServer(new(BuiltinEventHandler), &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true}}).Run(addr)
+	time.Sleep(100 * time.Millisecond)
+
+	// 共享同一个配置的并发连接各自协商拓展
+	// Concurrent connections sharing the same option negotiate extensions independently
+	var option = &ClientOption{Addr: "ws://" + addr, PermessageDeflate: PermessageDeflate{Enabled: true}}
+	client, _, err := NewClient(new(BuiltinEventHandler), option)
+	if !as.NoError(err) {
+		return
+	}
+	_ = client.NetConn().Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, _, err := NewClient(new(BuiltinEventHandler), option)
+			if as.NoError(err) {
+				as.True(client.pd.Enabled)
+				_ = client.NetConn().Close()
+			}
+		}()
+	}
+	wg.Wait()
+	as.Nil(option.Extensions)
+}
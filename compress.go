@@ -6,9 +6,9 @@ import (
 	"io"
 	"math"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/klauspost/compress/flate"
 	"github.com/lxzan/gws/internal"
@@ -18,53 +18,172 @@ import (
 // The tail marker of the deflate compression algorithm
 var flateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
 
-type deflaterPool struct {
+const (
+	// flate 压缩器占用内存的估算值
+	// Estimated memory footprint of a flate compressor
+	flateWriterSize = 512 * 1024
+
+	// flate 解压器占用内存的估算值
+	// Estimated memory footprint of a flate decompressor
+	flateReaderSize = 40 * 1024
+)
+
+// 压缩器池, 按顺序轮流选择, 池中的对象被多个连接共享
+// Compressor pool, selected in turn, the objects in the pool are shared by multiple connections
+type compressorPool[T any] struct {
 	serial uint64
 	num    uint64
-	pool   []*deflater
+	pool   []T
 }
 
-// 初始化deflaterPool
-// Initialize the deflaterPool
-func (c *deflaterPool) initialize(options PermessageDeflate, limit int) *deflaterPool {
-	c.num = uint64(options.PoolSize)
+// 初始化压缩器池, size 必须是 2 的幂
+// Initialize the compressor pool, size must be a power of 2
+func (c *compressorPool[T]) initialize(size int, newFunc func() T) {
+	c.num = uint64(size)
 	for i := uint64(0); i < c.num; i++ {
-		c.pool = append(c.pool, new(deflater).initialize(true, options, limit))
+		c.pool = append(c.pool, newFunc())
 	}
-	return c
 }
 
-// Select 从deflaterPool中选择一个deflater对象
-// Select a deflater object from the deflaterPool
-func (c *deflaterPool) Select() *deflater {
+// Select 从压缩器池中选择一个对象
+// Select an object from the compressor pool
+func (c *compressorPool[T]) Select() T {
 	var j = atomic.AddUint64(&c.serial, 1) & (c.num - 1)
 	return c.pool[j]
 }
 
+// 服务端共享的压缩器池. 配置的滑动窗口对应的池预先创建,
+// 客户端要求更小的窗口时按需创建对应窗口的池, 最多 8 个, 不会随连接数增长.
+// Compressors shared on the server. The pool of the configured sliding window is created up front,
+// pools of smaller windows requested by clients are created on demand, at most 8, never growing with the number of connections.
+type deflaterPool struct {
+	compressorPool[*deflater]
+
+	mu      sync.Mutex
+	options PermessageDeflate
+	limit   int
+	windows [8]*compressorPool[*deflater]
+}
+
+// 初始化deflaterPool
+// Initialize the deflaterPool
+func (c *deflaterPool) initialize(options PermessageDeflate, limit int) *deflaterPool {
+	c.options, c.limit = options, limit
+	c.compressorPool.initialize(options.PoolSize, c.newFunc(options))
+	return c
+}
+
+func (c *deflaterPool) newFunc(options PermessageDeflate) func() *deflater {
+	return func() *deflater {
+		var d = new(deflater).initialize(true, options, c.limit)
+		d.shared = true
+		return d
+	}
+}
+
+// 选择滑动窗口为 windowBits 的压缩器
+// Selects a compressor whose sliding window is windowBits
+func (c *deflaterPool) selectWindow(windowBits int) *deflater {
+	if windowBits == c.options.ServerMaxWindowBits {
+		return c.Select()
+	}
+	c.mu.Lock()
+	var pool = c.windows[windowBits-8]
+	if pool == nil {
+		var options = c.options
+		options.ServerMaxWindowBits = windowBits
+		pool = new(compressorPool[*deflater])
+		pool.initialize(options.PoolSize, c.newFunc(options))
+		c.windows[windowBits-8] = pool
+	}
+	c.mu.Unlock()
+	return pool.Select()
+}
+
 type deflater struct {
-	dpsLocker sync.Mutex
-	buf       []byte
-	limit     int
-	dpsBuffer *bytes.Buffer
-	dpsReader io.ReadCloser
-	cpsLocker sync.Mutex
-	cpsWriter *flate.Writer
+	// 是否被多个连接共享, 共享的压缩器不会在连接休眠时释放, 也不计入连接的内存占用
+	// Whether it is shared by multiple connections, shared compressors are neither released
+	// while a connection hibernates nor counted in the memory footprint of a connection
+	shared bool
+
+	dpsLocker  sync.Mutex
+	buf        []byte
+	limit      int
+	level      int
+	windowBits int
+	dpsBuffer  *bytes.Buffer
+	dpsReader  io.ReadCloser
+	cpsLocker  sync.Mutex
+	cpsWriter  *flate.Writer
 }
 
 // 初始化deflater
 // Initialize the deflater
 func (c *deflater) initialize(isServer bool, options PermessageDeflate, limit int) *deflater {
+	c.limit = limit
+	c.level = options.Level
+	c.windowBits = internal.SelectValue(isServer, options.ServerMaxWindowBits, options.ClientMaxWindowBits)
+	c.initReader()
+	c.cpsWriter = c.newWriter()
+	return c
+}
+
+// 初始化解压器
+// Initialize the decompressor
+func (c *deflater) initReader() {
 	c.dpsReader = flate.NewReader(nil)
 	c.dpsBuffer = bytes.NewBuffer(nil)
 	c.buf = make([]byte, 32*1024)
-	c.limit = limit
-	windowBits := internal.SelectValue(isServer, options.ServerMaxWindowBits, options.ClientMaxWindowBits)
-	if windowBits == 15 {
-		c.cpsWriter, _ = flate.NewWriter(nil, options.Level)
-	} else {
-		c.cpsWriter, _ = flate.NewWriterWindow(nil, internal.BinaryPow(windowBits))
+}
+
+// 创建压缩器
+// Create the compressor
+func (c *deflater) newWriter() *flate.Writer {
+	if c.windowBits == 15 {
+		w, _ := flate.NewWriter(nil, c.level)
+		return w
 	}
-	return c
+	w, _ := flate.NewWriterWindow(nil, internal.BinaryPow(c.windowBits))
+	return w
+}
+
+// 获取压缩器, 被释放后重新创建. 调用者需要持有 cpsLocker.
+// Get the compressor, recreated after being released. The caller must hold cpsLocker.
+func (c *deflater) writer() *flate.Writer {
+	if c.cpsWriter == nil {
+		c.cpsWriter = c.newWriter()
+	}
+	return c.cpsWriter
+}
+
+// 释放压缩器和解压器, 下次使用时重新创建
+// Release the compressor and decompressor, they are recreated on next use
+func (c *deflater) release() {
+	c.cpsLocker.Lock()
+	c.cpsWriter = nil
+	c.cpsLocker.Unlock()
+
+	c.dpsLocker.Lock()
+	c.dpsReader, c.dpsBuffer, c.buf = nil, nil, nil
+	c.dpsLocker.Unlock()
+}
+
+// 估算占用的内存
+// Estimate the memory footprint
+func (c *deflater) memoryUsage() int {
+	var n = 0
+	c.cpsLocker.Lock()
+	if c.cpsWriter != nil {
+		n += flateWriterSize
+	}
+	c.cpsLocker.Unlock()
+
+	c.dpsLocker.Lock()
+	if c.dpsReader != nil {
+		n += flateReaderSize + cap(c.buf) + c.dpsBuffer.Cap()
+	}
+	c.dpsLocker.Unlock()
+	return n
 }
 
 // 重置deflate reader
@@ -79,14 +198,23 @@ func (c *deflater) resetFR(r io.Reader, dict []byte) {
 }
 
 // Decompress 解压
+// 输出超过 limit 时中止解压并返回 limitErr, limit 不能放宽 deflater 自身的长度限制.
 // Decompress data
-func (c *deflater) Decompress(src *bytes.Buffer, dict []byte) (*bytes.Buffer, error) {
+// Decompression is aborted and limitErr is returned when the output exceeds limit,
+// limit cannot relax the length limit of the deflater itself.
+func (c *deflater) Decompress(src *bytes.Buffer, dict []byte, limit int, limitErr error) (*bytes.Buffer, error) {
 	c.dpsLocker.Lock()
 	defer c.dpsLocker.Unlock()
 
+	if c.dpsReader == nil {
+		c.initReader()
+	}
 	_, _ = src.Write(flateTail)
 	c.resetFR(src, dict)
-	reader := limitReader(c.dpsReader, c.limit)
+	if limit > c.limit {
+		limit, limitErr = c.limit, internal.CloseMessageTooLarge
+	}
+	reader := limitReader(c.dpsReader, limit, limitErr)
 	if _, err := io.CopyBuffer(c.dpsBuffer, reader, c.buf); err != nil {
 		return nil, err
 	}
@@ -97,10 +225,10 @@ func (c *deflater) Decompress(src *bytes.Buffer, dict []byte) (*bytes.Buffer, er
 
 // Compress 压缩
 // Compress data
-func (c *deflater) Compress(src internal.Payload, dst *bytes.Buffer, dict []byte) error {
+func (c *deflater) Compress(src io.WriterTo, dst *bytes.Buffer, dict []byte) error {
 	c.cpsLocker.Lock()
 	defer c.cpsLocker.Unlock()
-	if err := compressTo(c.cpsWriter, src, dst, dict); err != nil {
+	if err := compressTo(c.writer(), src, dst, dict); err != nil {
 		return err
 	}
 	if n := dst.Len(); n >= 4 {
@@ -171,102 +299,402 @@ func (c *slideWindow) Write(p []byte) (int, error) {
 	return total, nil
 }
 
-// 生成请求头
-// Generate request headers
-func (c *PermessageDeflate) genRequestHeader() string {
-	var options = make([]string, 0, 5)
-	options = append(options, internal.PermessageDeflate)
+// 自适应压缩
+// 按操作码分别统计压缩率, 节省的空间不足时暂停压缩, 每隔若干条消息重新探测一次.
+// 只有在无上下文接管模式下才会启用, 此时每条消息是否压缩可以独立决定, 对端无感知.
+// Adaptive compression
+// The compression ratio is sampled separately for each opcode, compression is paused when the savings are insufficient,
+// and probed again every few messages.
+// It is only enabled in context-free takeover mode, where each message can be compressed independently
+// and the peer is unaware of it.
+type adaptiveCompressor struct {
+	enabled  bool
+	ratio    float64
+	interval int
+	states   [3]adaptiveState
+}
+
+type adaptiveState struct {
+	// 是否暂停压缩
+	// Whether compression is paused
+	paused bool
+
+	// 暂停以来跳过的消息数量
+	// Number of messages skipped since the pause
+	skipped int
+}
+
+// 初始化自适应压缩
+// Initialize adaptive compression
+func (c *adaptiveCompressor) initialize(pd PermessageDeflate) *adaptiveCompressor {
+	c.enabled = pd.AdaptiveEnabled
+	c.ratio = pd.AdaptiveRatio
+	c.interval = pd.AdaptiveProbeInterval
+	return c
+}
+
+// 判断当前消息是否应该压缩
+// Determines whether the current message should be compressed
+func (c *adaptiveCompressor) shouldCompress(opcode Opcode) bool {
+	if !c.enabled || !opcode.isDataFrame() {
+		return true
+	}
+	var state = &c.states[opcode]
+	if !state.paused {
+		return true
+	}
+	if state.skipped++; state.skipped < c.interval {
+		return false
+	}
+	state.skipped = 0
+	return true
+}
+
+// 记录一次压缩的结果
+// Records the result of a compression
+func (c *adaptiveCompressor) observe(opcode Opcode, rawSize int, compressedSize int) {
+	if !c.enabled || !opcode.isDataFrame() || rawSize <= 0 {
+		return
+	}
+	var state = &c.states[opcode]
+	state.paused = float64(compressedSize) > float64(rawSize)*c.ratio
+	state.skipped = 0
+}
+
+// 生成请求参数
+// Generate request parameters
+func (c *PermessageDeflate) genRequestParams() []ExtensionParam {
+	var params = make([]ExtensionParam, 0, 4)
 	if !c.ServerContextTakeover {
-		options = append(options, internal.ServerNoContextTakeover)
+		params = append(params, ExtensionParam{Key: internal.ServerNoContextTakeover})
 	}
 	if !c.ClientContextTakeover {
-		options = append(options, internal.ClientNoContextTakeover)
+		params = append(params, ExtensionParam{Key: internal.ClientNoContextTakeover})
 	}
 	if c.ServerMaxWindowBits != 15 {
-		options = append(options, internal.ServerMaxWindowBits+internal.EQ+strconv.Itoa(c.ServerMaxWindowBits))
+		params = append(params, ExtensionParam{Key: internal.ServerMaxWindowBits, Value: strconv.Itoa(c.ServerMaxWindowBits)})
 	}
 	if c.ClientMaxWindowBits != 15 {
-		options = append(options, internal.ClientMaxWindowBits+internal.EQ+strconv.Itoa(c.ClientMaxWindowBits))
+		params = append(params, ExtensionParam{Key: internal.ClientMaxWindowBits, Value: strconv.Itoa(c.ClientMaxWindowBits)})
 	} else if c.ClientContextTakeover {
-		options = append(options, internal.ClientMaxWindowBits)
+		params = append(params, ExtensionParam{Key: internal.ClientMaxWindowBits})
 	}
-	return strings.Join(options, "; ")
+	return params
 }
 
-// 生成响应头
-// Generate response headers
-func (c *PermessageDeflate) genResponseHeader() string {
-	var options = make([]string, 0, 5)
-	options = append(options, internal.PermessageDeflate)
+// 生成响应参数
+// 客户端提议了 server_max_window_bits 时必须在响应中确认, 没有提议 client_max_window_bits 时响应中不能包含该参数.
+// Generate response parameters
+// server_max_window_bits must be confirmed in the response if the client offered it,
+// and client_max_window_bits must not be included if the client did not offer it.
+func (c *PermessageDeflate) genResponseParams(offer deflateParams) []ExtensionParam {
+	var params = make([]ExtensionParam, 0, 4)
 	if !c.ServerContextTakeover {
-		options = append(options, internal.ServerNoContextTakeover)
+		params = append(params, ExtensionParam{Key: internal.ServerNoContextTakeover})
 	}
 	if !c.ClientContextTakeover {
-		options = append(options, internal.ClientNoContextTakeover)
+		params = append(params, ExtensionParam{Key: internal.ClientNoContextTakeover})
 	}
-	if c.ServerMaxWindowBits != 15 {
-		options = append(options, internal.ServerMaxWindowBits+internal.EQ+strconv.Itoa(c.ServerMaxWindowBits))
+	if c.ServerMaxWindowBits != 15 || offer.serverMaxWindowBits > 0 {
+		params = append(params, ExtensionParam{Key: internal.ServerMaxWindowBits, Value: strconv.Itoa(c.ServerMaxWindowBits)})
 	}
-	if c.ClientMaxWindowBits != 15 {
-		options = append(options, internal.ClientMaxWindowBits+internal.EQ+strconv.Itoa(c.ClientMaxWindowBits))
+	if c.ClientMaxWindowBits != 15 && offer.clientMaxWindowBits > 0 {
+		params = append(params, ExtensionParam{Key: internal.ClientMaxWindowBits, Value: strconv.Itoa(c.ClientMaxWindowBits)})
 	}
-	return strings.Join(options, "; ")
+	return params
 }
 
-// 压缩拓展协商
-// Negotiation of compression parameters
-func permessageNegotiation(str string) PermessageDeflate {
-	var options = PermessageDeflate{
-		ServerContextTakeover: true,
-		ClientContextTakeover: true,
-		ServerMaxWindowBits:   15,
-		ClientMaxWindowBits:   15,
-	}
+// permessage-deflate 的协商参数
+// Negotiation parameters of permessage-deflate
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+
+	// 0 表示没有该参数
+	// 0 means the parameter is absent
+	serverMaxWindowBits int
 
-	var ss = internal.Split(str, ";")
-	for _, s := range ss {
-		var pair = strings.SplitN(s, "=", 2)
-		switch pair[0] {
-		case internal.PermessageDeflate:
+	// 0 表示没有该参数, 提议中没有值时为 15
+	// 0 means the parameter is absent, 15 if it has no value in an offer
+	clientMaxWindowBits int
+}
+
+// 按照 RFC 7692 解析并校验参数
+// 不认识的参数, 重复的参数, 非法的值都会返回错误. 只有提议中的 client_max_window_bits 可以没有值.
+// Parses and validates the parameters according to RFC 7692
+// Unknown parameters, duplicate parameters and invalid values all return an error.
+// Only client_max_window_bits in an offer may have no value.
+func parseDeflateParams(params []ExtensionParam, isResponse bool) (deflateParams, error) {
+	var result deflateParams
+	var seen = make(map[string]bool, len(params))
+	for _, param := range params {
+		if seen[param.Key] {
+			return result, ErrExtensionNegotiation
+		}
+		seen[param.Key] = true
+
+		var err error
+		switch param.Key {
 		case internal.ServerNoContextTakeover:
-			options.ServerContextTakeover = false
+			result.serverNoContextTakeover, err = true, checkEmptyValue(param.Value)
 		case internal.ClientNoContextTakeover:
-			options.ClientContextTakeover = false
+			result.clientNoContextTakeover, err = true, checkEmptyValue(param.Value)
 		case internal.ServerMaxWindowBits:
-			if len(pair) == 2 {
-				x, _ := strconv.Atoi(pair[1])
-				x = internal.WithDefault(x, 15)
-				options.ServerMaxWindowBits = internal.Min(options.ServerMaxWindowBits, x)
-			}
+			result.serverMaxWindowBits, err = parseWindowBits(param.Value)
 		case internal.ClientMaxWindowBits:
-			if len(pair) == 2 {
-				x, _ := strconv.Atoi(pair[1])
-				x = internal.WithDefault(x, 15)
-				options.ClientMaxWindowBits = internal.Min(options.ClientMaxWindowBits, x)
+			if param.Value == "" && !isResponse {
+				result.clientMaxWindowBits = 15
+			} else {
+				result.clientMaxWindowBits, err = parseWindowBits(param.Value)
 			}
+		default:
+			err = ErrExtensionNegotiation
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// 检查参数没有值
+// Checks that the parameter has no value
+func checkEmptyValue(value string) error {
+	if value != "" {
+		return ErrExtensionNegotiation
+	}
+	return nil
+}
+
+// 解析滑动窗口指数, 必须是 8 到 15 之间没有前导零的整数
+// Parses the sliding window index, which must be an integer between 8 and 15 without leading zeros
+func parseWindowBits(value string) (int, error) {
+	if value == "" || value[0] == '0' {
+		return 0, ErrExtensionNegotiation
+	}
+	var n = 0
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' || n > 15 {
+			return 0, ErrExtensionNegotiation
+		}
+		n = n*10 + int(value[i]-'0')
+	}
+	if n < 8 || n > 15 {
+		return 0, ErrExtensionNegotiation
+	}
+	return n, nil
+}
+
+// 基于拓展框架实现的 permessage-deflate
+// permessage-deflate implemented on top of the extension framework
+type permessageDeflateExtension struct {
+	isServer bool
+	option   PermessageDeflate
+	pool     *deflaterPool
+	limit    int
+}
+
+// 创建 permessage-deflate 拓展, 服务端的压缩器来自共享的压缩器池
+// Creates the permessage-deflate extension, the server-side compressors come from a shared pool
+func newPermessageDeflateExtension(isServer bool, option PermessageDeflate, pool *deflaterPool, limit int) *permessageDeflateExtension {
+	return &permessageDeflateExtension{isServer: isServer, option: option, pool: pool, limit: limit}
+}
+
+func (c *permessageDeflateExtension) Name() string { return internal.PermessageDeflate }
+
+func (c *permessageDeflateExtension) RSV() uint8 { return RSV1 }
+
+func (c *permessageDeflateExtension) Offer() []ExtensionParam { return c.option.genRequestParams() }
+
+func (c *permessageDeflateExtension) Accept(offer []ExtensionParam) ([]ExtensionParam, ExtensionHandler, error) {
+	clientPD, err := parseDeflateParams(offer, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var serverPD = c.option
+	var pd = PermessageDeflate{
+		Enabled:               true,
+		Threshold:             serverPD.Threshold,
+		Level:                 serverPD.Level,
+		PoolSize:              serverPD.PoolSize,
+		AdaptiveEnabled:       serverPD.AdaptiveEnabled,
+		AdaptiveRatio:         serverPD.AdaptiveRatio,
+		AdaptiveProbeInterval: serverPD.AdaptiveProbeInterval,
+		ServerContextTakeover: !clientPD.serverNoContextTakeover && serverPD.ServerContextTakeover,
+		ClientContextTakeover: !clientPD.clientNoContextTakeover && serverPD.ClientContextTakeover,
+		ServerMaxWindowBits:   serverPD.ServerMaxWindowBits,
+		ClientMaxWindowBits:   serverPD.ClientMaxWindowBits,
+	}
+	if clientPD.serverMaxWindowBits > 0 {
+		pd.ServerMaxWindowBits = internal.Min(pd.ServerMaxWindowBits, clientPD.serverMaxWindowBits)
+	}
+	if clientPD.clientMaxWindowBits > 0 {
+		pd.ClientMaxWindowBits = internal.Min(pd.ClientMaxWindowBits, clientPD.clientMaxWindowBits)
+	} else if pd.ClientMaxWindowBits != 15 {
+		// 客户端不支持限制滑动窗口, 关闭客户端上下文接管以免占用更大的解压字典
+		// The client does not support limiting the sliding window,
+		// turn off client context takeover to avoid a larger decompression dictionary
+		pd.ClientMaxWindowBits = 15
+		pd.ClientContextTakeover = false
+	}
+	pd.setThreshold(true)
+	return pd.genResponseParams(clientPD), &deflateHandler{ext: c, pd: pd}, nil
+}
+
+// 客户端校验服务端的响应
+// 客户端要求了 server_max_window_bits 时, 响应必须确认且不能超过要求的值; 没有提议 client_max_window_bits 时, 响应中不能包含该参数.
+// 服务端忽略 server_no_context_takeover 时, 客户端保留解压字典即可兼容.
+// The client verifies the server's response
+// If the client requested server_max_window_bits, the response must confirm it without exceeding the requested value;
+// if the client did not offer client_max_window_bits, the response must not include it.
+// If the server ignores server_no_context_takeover, the client stays compatible by keeping the decompression dictionary.
+func (c *permessageDeflateExtension) Confirm(response []ExtensionParam) (ExtensionHandler, error) {
+	serverPD, err := parseDeflateParams(response, true)
+	if err != nil {
+		return nil, err
+	}
+	offer, _ := parseDeflateParams(c.Offer(), false)
+	if offer.serverMaxWindowBits > 0 && (serverPD.serverMaxWindowBits == 0 || serverPD.serverMaxWindowBits > offer.serverMaxWindowBits) {
+		return nil, ErrExtensionNegotiation
+	}
+	if offer.clientMaxWindowBits == 0 && serverPD.clientMaxWindowBits > 0 {
+		return nil, ErrExtensionNegotiation
+	}
+
+	var clientPD = c.option
+	var pd = PermessageDeflate{
+		Enabled:               true,
+		Threshold:             clientPD.Threshold,
+		Level:                 clientPD.Level,
+		PoolSize:              clientPD.PoolSize,
+		AdaptiveEnabled:       clientPD.AdaptiveEnabled,
+		AdaptiveRatio:         clientPD.AdaptiveRatio,
+		AdaptiveProbeInterval: clientPD.AdaptiveProbeInterval,
+		ServerContextTakeover: !serverPD.serverNoContextTakeover,
+		ClientContextTakeover: !serverPD.clientNoContextTakeover && clientPD.ClientContextTakeover,
+		ServerMaxWindowBits:   internal.WithDefault(serverPD.serverMaxWindowBits, 15),
+		ClientMaxWindowBits:   internal.Min(clientPD.ClientMaxWindowBits, internal.WithDefault(serverPD.clientMaxWindowBits, 15)),
+	}
+	pd.setThreshold(false)
+	return &deflateHandler{ext: c, pd: pd}, nil
+}
+
+// permessage-deflate 连接级别的处理器, 压缩状态保存在 Conn 中
+// Connection-level handler of permessage-deflate, the compression state is kept in Conn
+type deflateHandler struct {
+	ext *permessageDeflateExtension
+	pd  PermessageDeflate
+}
+
+// 压缩字典和解压字典内存开销比较大, 故使用懒加载
+// Compressing and decompressing dictionaries has a large memory overhead, so use lazy loading.
+func (c *deflateHandler) bind(socket *Conn) {
+	var pd = c.pd
+	socket.pd = pd
+	socket.adaptive.initialize(pd)
+	if c.ext.isServer {
+		// 客户端可能要求更小的滑动窗口, 使用对应窗口的压缩器, 以免引用窗口之外的数据
+		// The client may request a smaller sliding window, a compressor of that window is used
+		// to avoid referencing data outside it
+		socket.deflater = c.ext.pool.selectWindow(pd.ServerMaxWindowBits)
+		if pd.ServerContextTakeover {
+			socket.cpsWindow.initialize(socket.config.cswPool, pd.ServerMaxWindowBits)
+		}
+		if pd.ClientContextTakeover {
+			socket.dpsWindow.initialize(socket.config.dswPool, pd.ClientMaxWindowBits)
 		}
+		return
 	}
 
-	options.ClientMaxWindowBits = internal.SelectValue(options.ClientMaxWindowBits < 8, 8, options.ClientMaxWindowBits)
-	options.ServerMaxWindowBits = internal.SelectValue(options.ServerMaxWindowBits < 8, 8, options.ServerMaxWindowBits)
-	return options
+	socket.deflater = new(deflater).initialize(false, pd, c.ext.limit)
+	if pd.ServerContextTakeover {
+		socket.dpsWindow.initialize(nil, pd.ServerMaxWindowBits)
+	}
+	if pd.ClientContextTakeover {
+		socket.cpsWindow.initialize(nil, pd.ClientMaxWindowBits)
+	}
 }
 
-// 限制从io.Reader中最多读取m个字节
-// Limit reading up to m bytes from io.Reader
-func limitReader(r io.Reader, m int) io.Reader { return &limitedReader{R: r, M: m} }
+func (c *deflateHandler) Encode(socket *Conn, opcode Opcode, payload ExtensionPayload, dst *bytes.Buffer) (bool, error) {
+	var n = payload.Len()
+	if n < c.pd.Threshold || !socket.adaptive.shouldCompress(opcode) {
+		return false, nil
+	}
+	var offset = dst.Len()
+	if err := socket.deflater.Compress(payload, dst, socket.cpsWindow.dict); err != nil {
+		return false, err
+	}
+	socket.adaptive.observe(opcode, n, dst.Len()-offset)
+	return true, nil
+}
+
+func (c *deflateHandler) Decode(socket *Conn, opcode Opcode, payload *bytes.Buffer) (*bytes.Buffer, error) {
+	limit, limitErr := socket.decompressLimit(payload.Len())
+	dst, err := socket.deflater.Decompress(payload, socket.dpsWindow.dict, limit, limitErr)
+	if err != nil {
+		return nil, err
+	}
+	socket.budget.consume(dst.Len())
+	_, _ = socket.dpsWindow.Write(dst.Bytes())
+	return dst, nil
+}
+
+// 限制从io.Reader中最多读取m个字节, 超出时返回err
+// Limit reading up to m bytes from io.Reader, returns err when exceeded
+func limitReader(r io.Reader, m int, err error) io.Reader {
+	return &limitedReader{R: r, M: m, Err: err}
+}
 
 type limitedReader struct {
-	R io.Reader
-	N int
-	M int
+	R   io.Reader
+	N   int
+	M   int
+	Err error
 }
 
 func (c *limitedReader) Read(p []byte) (n int, err error) {
 	n, err = c.R.Read(p)
 	c.N += n
 	if c.N > c.M {
-		return n, internal.CloseMessageTooLarge
+		return n, c.Err
 	}
 	return
 }
+
+// 解压预算, 按自然秒统计已解压的字节数
+// Decompression budget, counts the decompressed bytes per second
+type decompressBudget struct {
+	second int64
+	used   int
+}
+
+// 本秒剩余的预算
+// Remaining budget of the current second
+func (c *decompressBudget) remaining(rate int) int {
+	if now := time.Now().Unix(); now != c.second {
+		c.second, c.used = now, 0
+	}
+	return rate - c.used
+}
+
+// 消耗预算
+// Consumes the budget
+func (c *decompressBudget) consume(n int) { c.used += n }
+
+// 计算本次解压允许输出的最大字节数和超出时的错误, 取解压率和解压预算中更严格的一个
+// Calculates the maximum number of bytes allowed to be output by this decompression and the error when exceeded,
+// taking the stricter one of the decompression ratio and the decompression budget
+func (c *Conn) decompressLimit(compressedSize int) (int, error) {
+	var limit, limitErr = c.config.ReadMaxPayloadSize, error(internal.CloseMessageTooLarge)
+	if ratio := c.config.MaxDecompressionRatio; ratio > 0 {
+		if n := ratio * float64(compressedSize); n < float64(limit) {
+			limit, limitErr = int(n), ErrDecompressionRatio
+		}
+	}
+	if rate := c.config.DecompressionBytesPerSecond; rate > 0 {
+		if n := c.budget.remaining(rate); n < limit {
+			limit, limitErr = n, ErrDecompressionRate
+		}
+	}
+	return limit, limitErr
+}
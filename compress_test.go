@@ -1,8 +1,11 @@
 package gws
 
 import (
+	"bytes"
+	"crypto/rand"
 	"errors"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -57,20 +60,108 @@ func TestSlideWindow(t *testing.T) {
 }
 
 func TestNegotiation(t *testing.T) {
+	var negotiate = func(header string) deflateParams {
+		var offers = parseExtensions(header)
+		assert.NoError(t, offers[0].err)
+		params, err := parseDeflateParams(offers[0].params, false)
+		assert.NoError(t, err)
+		return params
+	}
+
 	t.Run("", func(t *testing.T) {
-		var pd = permessageNegotiation("permessage-deflate; client_no_context_takeover; client_max_window_bits=9")
-		assert.Equal(t, pd.ClientMaxWindowBits, 9)
-		assert.Equal(t, pd.ServerMaxWindowBits, 15)
-		assert.True(t, pd.ServerContextTakeover)
-		assert.False(t, pd.ClientContextTakeover)
+		var pd = negotiate("permessage-deflate; client_no_context_takeover; client_max_window_bits=9")
+		assert.Equal(t, pd.clientMaxWindowBits, 9)
+		assert.Equal(t, pd.serverMaxWindowBits, 0)
+		assert.False(t, pd.serverNoContextTakeover)
+		assert.True(t, pd.clientNoContextTakeover)
 	})
 
 	t.Run("", func(t *testing.T) {
-		var pd = permessageNegotiation("permessage-deflate; client_max_window_bits=9; server_max_window_bits=10")
-		assert.Equal(t, pd.ClientMaxWindowBits, 9)
-		assert.Equal(t, pd.ServerMaxWindowBits, 10)
-		assert.True(t, pd.ServerContextTakeover)
-		assert.True(t, pd.ClientContextTakeover)
+		var pd = negotiate(`permessage-deflate; client_max_window_bits="9"; server_max_window_bits=10`)
+		assert.Equal(t, pd.clientMaxWindowBits, 9)
+		assert.Equal(t, pd.serverMaxWindowBits, 10)
+		assert.False(t, pd.serverNoContextTakeover)
+		assert.False(t, pd.clientNoContextTakeover)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var headers = []string{
+			"permessage-deflate; server_max_window_bits",
+			"permessage-deflate; server_max_window_bits=7",
+			"permessage-deflate; server_max_window_bits=16",
+			"permessage-deflate; server_max_window_bits=010",
+			"permessage-deflate; client_max_window_bits=1a",
+			"permessage-deflate; client_no_context_takeover=1",
+			"permessage-deflate; client_no_context_takeover; client_no_context_takeover",
+			"permessage-deflate; unknown",
+		}
+		for _, header := range headers {
+			_, err := parseDeflateParams(parseExtensions(header)[0].params, false)
+			assert.ErrorIs(t, err, ErrExtensionNegotiation, header)
+		}
+
+		_, err := parseDeflateParams([]ExtensionParam{{Key: internal.ClientMaxWindowBits}}, true)
+		assert.ErrorIs(t, err, ErrExtensionNegotiation)
+	})
+
+	t.Run("multiple offers", func(t *testing.T) {
+		var upgrader = NewUpgrader(new(BuiltinEventHandler), &ServerOption{PermessageDeflate: PermessageDeflate{
+			Enabled:               true,
+			ServerContextTakeover: true,
+			ClientContextTakeover: true,
+			ServerMaxWindowBits:   12,
+			ClientMaxWindowBits:   12,
+		}})
+		var header = "permessage-deflate; server_max_window_bits=7, permessage-deflate; foo, " +
+			"permessage-deflate; server_max_window_bits=10; client_max_window_bits, permessage-deflate"
+		response, results := negotiateExtensions(header, upgrader.extensions)
+		assert.Equal(t, "permessage-deflate; server_max_window_bits=10; client_max_window_bits=12", response)
+		if assert.Equal(t, 1, len(results)) {
+			var socket = &Conn{isServer: true, config: upgrader.option.getConfig()}
+			socket.setExtensions(results)
+			assert.Equal(t, 10, socket.deflater.windowBits)
+			assert.True(t, socket.deflater.shared)
+		}
+
+		// 客户端没有提议 client_max_window_bits 时, 服务端关闭客户端上下文接管
+		response, _ = negotiateExtensions("permessage-deflate", upgrader.extensions)
+		assert.Equal(t, "permessage-deflate; client_no_context_takeover; server_max_window_bits=12", response)
+
+		response, results = negotiateExtensions(`permessage-deflate; client_max_window_bits="1"`, upgrader.extensions)
+		assert.Equal(t, "", response)
+		assert.Equal(t, 0, len(results))
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		var option = initClientOption(&ClientOption{PermessageDeflate: PermessageDeflate{
+			Enabled:             true,
+			ServerMaxWindowBits: 10,
+		}})
+		var extensions = option.newExtensions()
+		assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=10", offerExtensions(extensions))
+
+		var responses = []string{
+			"permessage-deflate; server_no_context_takeover; server_max_window_bits=11",
+			"permessage-deflate; server_no_context_takeover",
+			"permessage-deflate; server_no_context_takeover; server_max_window_bits=10; client_max_window_bits=10",
+			"permessage-deflate; server_no_context_takeover; server_max_window_bits=10; x=1",
+			"permessage-deflate; server_no_context_takeover; server_max_window_bits=10; =1",
+		}
+		for _, response := range responses {
+			_, err := confirmExtensions(response, extensions)
+			assert.ErrorIs(t, err, ErrExtensionNegotiation, response)
+		}
+
+		results, err := confirmExtensions("permessage-deflate; server_no_context_takeover; server_max_window_bits=9", extensions)
+		assert.NoError(t, err)
+		var pd = results[0].handler.(*deflateHandler).pd
+		assert.Equal(t, 9, pd.ServerMaxWindowBits)
+		assert.False(t, pd.ServerContextTakeover)
+		assert.False(t, pd.ClientContextTakeover)
+
+		results, err = confirmExtensions("permessage-deflate; server_max_window_bits=10", extensions)
+		assert.NoError(t, err)
+		assert.True(t, results[0].handler.(*deflateHandler).pd.ServerContextTakeover)
 	})
 }
 
@@ -263,3 +354,399 @@ func (c *writerTo) WriteTo(w io.Writer) (n int64, err error) {
 func (c *writerTo) Read(p []byte) (n int, err error) {
 	return 0, errors.New("1")
 }
+
+func TestAdaptiveCompressor(t *testing.T) {
+	t.Run("pause and probe", func(t *testing.T) {
+		var c = new(adaptiveCompressor).initialize(PermessageDeflate{
+			AdaptiveEnabled:       true,
+			AdaptiveRatio:         0.9,
+			AdaptiveProbeInterval: 3,
+		})
+		assert.True(t, c.shouldCompress(OpcodeText))
+		c.observe(OpcodeText, 100, 95)
+		assert.True(t, c.states[OpcodeText].paused)
+
+		assert.False(t, c.shouldCompress(OpcodeText))
+		assert.False(t, c.shouldCompress(OpcodeText))
+		assert.True(t, c.shouldCompress(OpcodeBinary))
+		assert.True(t, c.shouldCompress(OpcodePing))
+		assert.True(t, c.shouldCompress(OpcodeText))
+
+		c.observe(OpcodeText, 100, 50)
+		assert.False(t, c.states[OpcodeText].paused)
+		assert.True(t, c.shouldCompress(OpcodeText))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var c = new(adaptiveCompressor).initialize(PermessageDeflate{})
+		c.observe(OpcodeText, 100, 100)
+		assert.True(t, c.shouldCompress(OpcodeText))
+	})
+
+	t.Run("context takeover", func(t *testing.T) {
+		var option = initServerOption(&ServerOption{PermessageDeflate: PermessageDeflate{
+			Enabled:               true,
+			AdaptiveEnabled:       true,
+			ServerContextTakeover: true,
+		}})
+		var pd = option.PermessageDeflate
+		pd.setThreshold(true)
+		assert.False(t, pd.AdaptiveEnabled)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		var serverHandler = new(webSocketMocker)
+		var clientHandler = new(webSocketMocker)
+		var serverOption = &ServerOption{PermessageDeflate: PermessageDeflate{
+			Enabled:         true,
+			Threshold:       1,
+			AdaptiveEnabled: true,
+			AdaptiveRatio:   0.5,
+		}}
+		var clientOption = &ClientOption{PermessageDeflate: PermessageDeflate{Enabled: true}}
+		server, client := newPeer(serverHandler, serverOption, clientHandler, clientOption)
+		server.adaptive.initialize(server.pd)
+
+		var payload = make([]byte, 1024)
+		_, _ = rand.Read(payload)
+		var wg = &sync.WaitGroup{}
+		wg.Add(2)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			assert.Equal(t, payload, message.Bytes())
+			wg.Done()
+		}
+		go client.ReadLoop()
+
+		assert.NoError(t, server.WriteMessage(OpcodeBinary, payload))
+		assert.True(t, server.adaptive.states[OpcodeBinary].paused)
+		assert.NoError(t, server.WriteMessage(OpcodeBinary, payload))
+		assert.Equal(t, 1, server.adaptive.states[OpcodeBinary].skipped)
+		wg.Wait()
+	})
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("deflater", func(t *testing.T) {
+		var d = new(deflater).initialize(true, PermessageDeflate{ServerMaxWindowBits: 15, Level: defaultCompressLevel}, 1024*1024)
+		var src = bytes.NewBuffer(nil)
+		as.NoError(d.Compress(internal.Bytes(make([]byte, 64*1024)), src, nil))
+		var n = src.Len()
+
+		_, err := d.Decompress(bytes.NewBuffer(src.Bytes()), nil, 10*n, ErrDecompressionRatio)
+		as.ErrorIs(err, ErrDecompressionRatio)
+
+		_, err = d.Decompress(bytes.NewBuffer(src.Bytes()), nil, 2*1024*1024, ErrDecompressionRate)
+		as.NoError(err)
+
+		var small = new(deflater).initialize(true, PermessageDeflate{ServerMaxWindowBits: 15, Level: defaultCompressLevel}, 1024)
+		_, err = small.Decompress(bytes.NewBuffer(src.Bytes()), nil, 2*1024*1024, ErrDecompressionRate)
+		as.ErrorIs(err, internal.CloseMessageTooLarge)
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		var b = decompressBudget{second: time.Now().Unix()}
+		b.consume(600)
+		as.LessOrEqual(b.remaining(1000), 400)
+		b.second--
+		as.Equal(1000, b.remaining(1000))
+	})
+
+	var run = func(option *ServerOption, payload []byte, expected error) {
+		var addr = "127.0.0.1:" + nextPort()
+		var wg = &sync.WaitGroup{}
+		wg.Add(2)
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onClose = func(socket *Conn, err error) {
+			as.ErrorIs(err, expected)
+			wg.Done()
+		}
+		option.PermessageDeflate = PermessageDeflate{Enabled: true}
+		var server = NewServer(serverHandler, option)
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onClose = func(socket *Conn, err error) {
+			var closeErr *CloseError
+			as.True(errors.As(err, &closeErr))
+			as.Equal(internal.CloseMessageTooLarge.Uint16(), closeErr.Code)
+			as.Equal(expected.Error(), string(closeErr.Reason))
+			wg.Done()
+		}
+		client, _, err := NewClient(clientHandler, &ClientOption{
+			Addr:              "ws://" + addr,
+			PermessageDeflate: PermessageDeflate{Enabled: true},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		go client.ReadLoop()
+		as.NoError(client.WriteMessage(OpcodeBinary, payload))
+		wg.Wait()
+
+		var metrics = server.Metrics()
+		as.Equal(uint64(internal.SelectValue(expected == ErrDecompressionRatio, 1, 0)), metrics.DecompressionRatioExceeded)
+		as.Equal(uint64(internal.SelectValue(expected == ErrDecompressionRate, 1, 0)), metrics.DecompressionRateExceeded)
+	}
+
+	t.Run("ratio", func(t *testing.T) {
+		run(&ServerOption{MaxDecompressionRatio: 10}, make([]byte, 1024*1024), ErrDecompressionRatio)
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		run(&ServerOption{DecompressionBytesPerSecond: 1024}, make([]byte, 4096), ErrDecompressionRate)
+	})
+}
+
+// 解压 DEFLATE 数据, 回溯距离超过 1<<windowBits 时返回错误. 数据在块边界结束时停止解压.
+// Inflates DEFLATE data, an error is returned if a back-reference distance exceeds 1<<windowBits.
+// Decompression stops when the data ends on a block boundary.
+func inflateWindow(data []byte, windowBits int) ([]byte, error) {
+	var errCorrupt = errors.New("corrupt deflate data")
+	var pos, bitBuf, bitCnt = 0, 0, 0
+	var bits = func(n int) (int, error) {
+		for bitCnt < n {
+			if pos >= len(data) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			bitBuf |= int(data[pos]) << bitCnt
+			pos++
+			bitCnt += 8
+		}
+		var v = bitBuf & (1<<n - 1)
+		bitBuf >>= n
+		bitCnt -= n
+		return v, nil
+	}
+
+	type huffman struct{ count, symbol []int }
+	var build = func(lengths []int) huffman {
+		var h = huffman{count: make([]int, 16), symbol: make([]int, len(lengths))}
+		for _, l := range lengths {
+			h.count[l]++
+		}
+		var offs = make([]int, 16)
+		for i := 1; i < 15; i++ {
+			offs[i+1] = offs[i] + h.count[i]
+		}
+		for sym, l := range lengths {
+			if l != 0 {
+				h.symbol[offs[l]] = sym
+				offs[l]++
+			}
+		}
+		return h
+	}
+	var decode = func(h huffman) (int, error) {
+		var code, first, index = 0, 0, 0
+		for l := 1; l < 16; l++ {
+			b, err := bits(1)
+			if err != nil {
+				return 0, err
+			}
+			code |= b
+			if count := h.count[l]; code-count < first {
+				return h.symbol[index+(code-first)], nil
+			} else {
+				index += count
+				first += count
+			}
+			first <<= 1
+			code <<= 1
+		}
+		return 0, errCorrupt
+	}
+
+	var lengthBase = []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	var lengthExtra = []int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	var distBase = []int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	var distExtra = []int{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	var out []byte
+	var codes = func(lencode, distcode huffman) error {
+		for {
+			sym, err := decode(lencode)
+			if err != nil {
+				return err
+			}
+			if sym < 256 {
+				out = append(out, byte(sym))
+				continue
+			}
+			if sym == 256 {
+				return nil
+			}
+			if sym -= 257; sym >= 29 {
+				return errCorrupt
+			}
+			extra, err := bits(lengthExtra[sym])
+			if err != nil {
+				return err
+			}
+			var length = lengthBase[sym] + extra
+			if sym, err = decode(distcode); err != nil {
+				return err
+			}
+			if sym >= 30 {
+				return errCorrupt
+			}
+			if extra, err = bits(distExtra[sym]); err != nil {
+				return err
+			}
+			var dist = distBase[sym] + extra
+			if dist > len(out) || dist > 1<<windowBits {
+				return errors.New("distance too far back")
+			}
+			for i := 0; i < length; i++ {
+				out = append(out, out[len(out)-dist])
+			}
+		}
+	}
+
+	for last := 0; last == 0 && pos < len(data); {
+		last, _ = bits(1)
+		typ, err := bits(2)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 0:
+			bitBuf, bitCnt = 0, 0
+			if pos+4 > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			var n = int(data[pos]) | int(data[pos+1])<<8
+			pos += 4
+			if pos+n > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			out = append(out, data[pos:pos+n]...)
+			pos += n
+		case 1:
+			var lengths = make([]int, 288+30)
+			for i := range lengths[:288] {
+				lengths[i] = internal.SelectValue(i < 144, 8, internal.SelectValue(i < 256, 9, internal.SelectValue(i < 280, 7, 8)))
+			}
+			for i := 288; i < len(lengths); i++ {
+				lengths[i] = 5
+			}
+			err = codes(build(lengths[:288]), build(lengths[288:]))
+		case 2:
+			var nlen, ndist, ncode int
+			if nlen, err = bits(5); err == nil {
+				if ndist, err = bits(5); err == nil {
+					ncode, err = bits(4)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			nlen, ndist, ncode = nlen+257, ndist+1, ncode+4
+			var order = []int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+			var lengths = make([]int, 19)
+			for i := 0; i < ncode; i++ {
+				if lengths[order[i]], err = bits(3); err != nil {
+					return nil, err
+				}
+			}
+			var lencode = build(lengths)
+			lengths = make([]int, 0, nlen+ndist)
+			for len(lengths) < nlen+ndist {
+				sym, err := decode(lencode)
+				if err != nil {
+					return nil, err
+				}
+				if sym < 16 {
+					lengths = append(lengths, sym)
+					continue
+				}
+				var value, repeat = 0, 0
+				switch sym {
+				case 16:
+					if len(lengths) == 0 {
+						return nil, errCorrupt
+					}
+					value = lengths[len(lengths)-1]
+					repeat, err = bits(2)
+					repeat += 3
+				case 17:
+					repeat, err = bits(3)
+					repeat += 3
+				default:
+					repeat, err = bits(7)
+					repeat += 11
+				}
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i < repeat; i++ {
+					lengths = append(lengths, value)
+				}
+			}
+			if len(lengths) > nlen+ndist {
+				return nil, errCorrupt
+			}
+			err = codes(build(lengths[:nlen]), build(lengths[nlen:]))
+		default:
+			return nil, errCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func TestNegotiatedWindow(t *testing.T) {
+	var as = assert.New(t)
+	var upgrader = NewUpgrader(new(BuiltinEventHandler), &ServerOption{PermessageDeflate: PermessageDeflate{
+		Enabled:               true,
+		ServerContextTakeover: false,
+		ClientContextTakeover: false,
+	}})
+	var header = "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=10"
+	response, results := negotiateExtensions(header, upgrader.extensions)
+	as.Contains(response, "server_max_window_bits=10")
+	if !as.Equal(1, len(results)) {
+		return
+	}
+	var socket = &Conn{isServer: true, config: upgrader.option.getConfig()}
+	socket.setExtensions(results)
+	as.Equal(10, socket.deflater.windowBits)
+
+	// 相同窗口的连接共享同一个池, 不会为每个连接创建压缩器
+	// Connections with the same window share a pool, no compressor is created per connection
+	as.True(socket.deflater.shared)
+	for i := 0; i < 2*upgrader.option.PermessageDeflate.PoolSize; i++ {
+		_, results = negotiateExtensions(header, upgrader.extensions)
+		var other = &Conn{isServer: true, config: upgrader.option.getConfig()}
+		other.setExtensions(results)
+		as.Equal(10, other.deflater.windowBits)
+		as.Contains(upgrader.deflaterPool.windows[10-8].pool, other.deflater)
+	}
+	as.Nil(upgrader.deflaterPool.windows[9-8])
+
+	// 重复的随机数据, 使用 32KB 窗口的压缩器会回溯 8KB
+	// Repeated random data, a compressor with a 32KB window would reference 8KB back
+	var block = make([]byte, 8*1024)
+	_, _ = rand.Read(block)
+	var payload = bytes.Repeat(block, 4)
+	var dst = bytes.NewBuffer(nil)
+	if !as.NoError(socket.deflater.Compress(internal.Bytes(payload), dst, nil)) {
+		return
+	}
+	dst.Write([]byte{0x00, 0x00, 0xff, 0xff})
+	output, err := inflateWindow(dst.Bytes(), 10)
+	as.NoError(err)
+	as.True(bytes.Equal(payload, output))
+
+	// 池中的压缩器使用 32KB 窗口
+	// The pooled compressors use a 32KB window
+	dst.Reset()
+	as.NoError(upgrader.deflaterPool.Select().Compress(internal.Bytes(payload), dst, nil))
+	dst.Write([]byte{0x00, 0x00, 0xff, 0xff})
+	_, err = inflateWindow(dst.Bytes(), 10)
+	as.Error(err)
+}
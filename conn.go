@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"net"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,6 +37,10 @@ type Conn struct {
 	// Subprotocol
 	subprotocol string
 
+	// 升级请求的副本, 客户端为 nil
+	// Copy of the upgrade request, nil on the client
+	request *http.Request
+
 	// 底层网络连接
 	// Underlying network connection
 	conn net.Conn
@@ -87,6 +92,30 @@ type Conn struct {
 	// 压缩拓展配置
 	// Compression extension configuration
 	pd PermessageDeflate
+
+	// 自适应压缩状态
+	// Adaptive compression state
+	adaptive adaptiveCompressor
+
+	// 协商成功的拓展
+	// Successfully negotiated extensions
+	extensions []negotiatedExtension
+
+	// 拓展占用的保留位
+	// Reserved bits occupied by the extensions
+	rsvMask uint8
+
+	// 休眠状态
+	// Hibernation state
+	hibernation hibernation
+
+	// 解压预算
+	// Decompression budget
+	budget decompressBudget
+
+	// 释放准入名额, ReadLoop 返回时调用
+	// Releases the admission slot, called when ReadLoop returns
+	release func()
 }
 
 // ReadLoop
@@ -99,7 +128,11 @@ func (c *Conn) ReadLoop() {
 	// 无限循环读取消息, 如果发生错误则触发错误事件并退出循环
 	// Infinite loop to read messages, if an error occurs, trigger the error event and exit the loop
 	for {
-		if err := c.readMessage(); err != nil {
+		var err = c.waitData()
+		if err == nil {
+			err = c.readMessage()
+		}
+		if err != nil {
 			c.emitError(true, err)
 			break
 		}
@@ -123,6 +156,9 @@ func (c *Conn) ReadLoop() {
 			c.dpsWindow.dict = nil
 		}
 	}
+	if c.release != nil {
+		c.release()
+	}
 }
 
 // 检查连接是否已关闭
@@ -200,6 +236,7 @@ func (c *Conn) emitClose(buf *bytes.Buffer) error {
 // SetDeadline 设置连接的截止时间
 // Sets the deadline for the connection
 func (c *Conn) SetDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
 	err := c.conn.SetDeadline(t)
 	c.emitError(false, err)
 	return err
@@ -208,6 +245,7 @@ func (c *Conn) SetDeadline(t time.Time) error {
 // SetReadDeadline 设置读取操作的截止时间
 // Sets the deadline for read operations
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
 	err := c.conn.SetReadDeadline(t)
 	c.emitError(false, err)
 	return err
@@ -262,6 +300,38 @@ func (c *Conn) SetNoDelay(noDelay bool) error {
 // Gets the negotiated sub-protocol
 func (c *Conn) SubProtocol() string { return c.subprotocol }
 
+// Request 获取升级请求的副本, 包括路径, 查询参数, 请求头和 Cookie, 不包含请求体. 客户端返回 nil.
+// Gets a copy of the upgrade request, including the path, query, headers and cookies, without the body.
+// Returns nil on the client.
+func (c *Conn) Request() *http.Request { return c.request }
+
+// ClientIP 获取客户端的真实 IP, 仅当直连地址属于 ServerOption.TrustedProxies 时才使用 Forwarded 或者 X-Forwarded-For
+// Gets the real IP of the client, Forwarded or X-Forwarded-For is used only if the direct address
+// belongs to ServerOption.TrustedProxies
+func (c *Conn) ClientIP() string {
+	if c.request == nil {
+		return remoteHost(c.RemoteAddr())
+	}
+	return resolveClientIP(c.request, c.RemoteAddr(), c.config.trustedProxies)
+}
+
+// TLSState 获取 TLS 连接状态, 非 TLS 连接返回 nil
+// Gets the TLS connection state, returns nil for non-TLS connections
+func (c *Conn) TLSState() *tls.ConnectionState {
+	if c.request != nil && c.request.TLS != nil {
+		return c.request.TLS
+	}
+	if conn, ok := c.conn.(*tls.Conn); ok {
+		var state = conn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+// PeerIdentity 获取经过验证的对端证书身份 (mTLS), 没有验证过的证书时返回 nil
+// Gets the identity of the verified peer certificate (mTLS), returns nil if there is no verified certificate
+func (c *Conn) PeerIdentity() *PeerIdentity { return newPeerIdentity(c.TLSState()) }
+
 // Session 获取会话存储
 // Gets the session storage
 func (c *Conn) Session() SessionStorage { return c.ss }
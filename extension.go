@@ -0,0 +1,322 @@
+package gws
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/lxzan/gws/internal"
+)
+
+const (
+	// RSV1 保留位1, 由 permessage-deflate 占用
+	// Reserved bit 1, occupied by permessage-deflate
+	RSV1 uint8 = 0x40
+
+	// RSV2 保留位2
+	// Reserved bit 2
+	RSV2 uint8 = 0x20
+
+	// RSV3 保留位3
+	// Reserved bit 3
+	RSV3 uint8 = 0x10
+)
+
+type (
+	// ExtensionParam 拓展参数, 没有值的参数 Value 为空字符串
+	// Extension parameter, Value is an empty string for parameters without a value
+	ExtensionParam struct {
+		Key   string
+		Value string
+	}
+
+	// ExtensionPayload 待编码的消息内容, 可能由多个切片组成
+	// The message content to be encoded, which may consist of multiple slices
+	ExtensionPayload interface {
+		io.WriterTo
+		Len() int
+	}
+
+	// Extension WebSocket 拓展
+	// 负责 Sec-WebSocket-Extensions 协商, 协商成功后为每个连接创建一个 ExtensionHandler.
+	// Broadcaster, PreparedMessage 和 WriteFile 只使用内置的 permessage-deflate, 不经过自定义拓展.
+	// WebSocket extension
+	// Responsible for the Sec-WebSocket-Extensions negotiation,
+	// and creates an ExtensionHandler for each connection after a successful negotiation.
+	// Broadcaster, PreparedMessage and WriteFile only use the built-in permessage-deflate and bypass custom extensions.
+	Extension interface {
+		// Name 拓展名称, 例如 permessage-deflate
+		// Extension name, e.g. permessage-deflate
+		Name() string
+
+		// RSV 拓展占用的保留位, RSV1/RSV2/RSV3 的组合, 不能与其他拓展冲突
+		// The reserved bits occupied by the extension, a combination of RSV1/RSV2/RSV3, must not conflict with other extensions.
+		RSV() uint8
+
+		// Offer 客户端: 生成握手请求中携带的拓展参数
+		// Client side: generates the extension parameters carried in the handshake request
+		Offer() []ExtensionParam
+
+		// Accept 服务端: 根据客户端提供的参数进行协商, 返回响应参数和连接级别的处理器.
+		// 返回错误表示不接受该提议, 不会导致握手失败.
+		// Server side: negotiates based on the parameters offered by the client,
+		// returns the response parameters and a connection-level handler.
+		// Returning an error means the offer is declined, which does not fail the handshake.
+		Accept(offer []ExtensionParam) ([]ExtensionParam, ExtensionHandler, error)
+
+		// Confirm 客户端: 根据服务端响应的参数创建连接级别的处理器, 返回错误会导致握手失败.
+		// Client side: creates a connection-level handler based on the server response parameters,
+		// returning an error fails the handshake.
+		Confirm(response []ExtensionParam) (ExtensionHandler, error)
+	}
+
+	// ExtensionHandler 连接级别的拓展处理器
+	// 同一个连接上 Encode 和 Decode 分别是串行调用的.
+	// Connection-level extension handler
+	// Encode and Decode are each called serially on the same connection.
+	ExtensionHandler interface {
+		// Encode 编码出站消息, 将结果追加写入 dst.
+		// 返回 false 表示该消息不经过此拓展, 此时不能修改 dst, 对应的保留位也不会被设置.
+		// Encodes an outbound message and appends the result to dst.
+		// Returning false means the message bypasses this extension, dst must not be modified
+		// and the corresponding reserved bits will not be set.
+		Encode(socket *Conn, opcode Opcode, payload ExtensionPayload, dst *bytes.Buffer) (bool, error)
+
+		// Decode 解码入站消息, 仅当消息的第一帧设置了拓展声明的保留位时调用.
+		// 不要持有 payload, 返回值的所有权转移给 Message.
+		// Decodes an inbound message, called only if the first frame of the message has the reserved bits of the extension set.
+		// Do not retain payload, ownership of the return value is transferred to the Message.
+		Decode(socket *Conn, opcode Opcode, payload *bytes.Buffer) (*bytes.Buffer, error)
+	}
+
+	// 拓展的提议或响应
+	// An extension offer or response
+	extensionOffer struct {
+		name   string
+		params []ExtensionParam
+		err    error
+	}
+
+	// 协商成功的拓展
+	// A successfully negotiated extension
+	negotiatedExtension struct {
+		rsv     uint8
+		handler ExtensionHandler
+	}
+
+	// 内置拓展在连接建立时绑定连接级别的状态
+	// Built-in extensions bind connection-level state when the connection is established
+	extensionBinder interface {
+		bind(socket *Conn)
+	}
+)
+
+// 解析 Sec-WebSocket-Extensions 头部
+// 格式错误的提议会被标记, 参数值可以是 token 或者 quoted-string.
+// Parses the Sec-WebSocket-Extensions header
+// Malformed offers are marked, parameter values can be tokens or quoted-strings.
+func parseExtensions(header string) []extensionOffer {
+	var offers []extensionOffer
+	for _, item := range internal.SplitQuoted(header, ',') {
+		offers = append(offers, parseExtension(item))
+	}
+	return offers
+}
+
+// 解析单个拓展的提议或响应
+// Parses a single extension offer or response
+func parseExtension(item string) extensionOffer {
+	var list = internal.SplitQuoted(item, ';')
+	var offer = extensionOffer{name: list[0]}
+	if !internal.IsToken(offer.name) {
+		offer.err = ErrExtensionNegotiation
+	}
+	for _, s := range list[1:] {
+		var param ExtensionParam
+		var ok = true
+		if i := strings.IndexByte(s, '='); i < 0 {
+			param.Key = s
+		} else {
+			param.Key = strings.TrimSpace(s[:i])
+			param.Value = strings.TrimSpace(s[i+1:])
+			if strings.HasPrefix(param.Value, `"`) {
+				param.Value, ok = internal.Unquote(param.Value)
+			}
+			ok = ok && internal.IsToken(param.Value)
+		}
+		if !ok || !internal.IsToken(param.Key) {
+			offer.err = ErrExtensionNegotiation
+		}
+		offer.params = append(offer.params, param)
+	}
+	return offer
+}
+
+// 生成拓展的头部描述
+// Generates the header description of the extension
+func formatExtension(name string, params []ExtensionParam) string {
+	var options = make([]string, 0, len(params)+1)
+	options = append(options, name)
+	for _, item := range params {
+		if item.Value == "" {
+			options = append(options, item.Key)
+		} else {
+			options = append(options, item.Key+internal.EQ+item.Value)
+		}
+	}
+	return strings.Join(options, "; ")
+}
+
+// 服务端拓展协商
+// 按照客户端的偏好顺序依次尝试, 每个拓展最多接受一次, 格式错误或者保留位冲突的提议会被跳过.
+// Server-side extension negotiation
+// Offers are tried in the client's order of preference, each extension is accepted at most once,
+// and malformed offers or offers with conflicting reserved bits are skipped.
+func negotiateExtensions(header string, extensions []Extension) (string, []negotiatedExtension) {
+	var responses []string
+	var results []negotiatedExtension
+	var rsvMask uint8
+	var accepted = make(map[string]bool, len(extensions))
+	for _, offer := range parseExtensions(header) {
+		for _, ext := range extensions {
+			if offer.err != nil || ext.Name() != offer.name || accepted[offer.name] || ext.RSV()&rsvMask != 0 {
+				continue
+			}
+			params, handler, err := ext.Accept(offer.params)
+			if err != nil {
+				continue
+			}
+			accepted[offer.name] = true
+			rsvMask |= ext.RSV()
+			responses = append(responses, formatExtension(ext.Name(), params))
+			results = append(results, negotiatedExtension{rsv: ext.RSV(), handler: handler})
+			break
+		}
+	}
+	return strings.Join(responses, ", "), results
+}
+
+// 生成客户端的拓展提议
+// Generates the client's extension offers
+func offerExtensions(extensions []Extension) string {
+	var offers = make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		offers = append(offers, formatExtension(ext.Name(), ext.Offer()))
+	}
+	return strings.Join(offers, ", ")
+}
+
+// 客户端确认服务端的拓展响应
+// 响应的格式必须正确, 不能包含未提议的拓展, 同一个拓展不能出现两次, 保留位不能冲突.
+// The client confirms the server's extension response
+// The response must be well-formed, must not contain extensions that were not offered, the same extension must not appear twice,
+// and the reserved bits must not conflict.
+func confirmExtensions(header string, extensions []Extension) ([]negotiatedExtension, error) {
+	var results []negotiatedExtension
+	var rsvMask uint8
+	var confirmed = make(map[string]bool, len(extensions))
+	for _, response := range parseExtensions(header) {
+		if response.err != nil {
+			return nil, response.err
+		}
+		var ext Extension
+		for _, item := range extensions {
+			if item.Name() == response.name {
+				ext = item
+				break
+			}
+		}
+		if ext == nil || confirmed[response.name] || ext.RSV()&rsvMask != 0 {
+			return nil, ErrExtensionNegotiation
+		}
+		handler, err := ext.Confirm(response.params)
+		if err != nil {
+			return nil, err
+		}
+		confirmed[response.name] = true
+		rsvMask |= ext.RSV()
+		results = append(results, negotiatedExtension{rsv: ext.RSV(), handler: handler})
+	}
+	return results, nil
+}
+
+// 安装协商成功的拓展
+// Installs the successfully negotiated extensions
+func (c *Conn) setExtensions(extensions []negotiatedExtension) {
+	c.extensions = extensions
+	for _, item := range extensions {
+		c.rsvMask |= item.rsv
+		if binder, ok := item.handler.(extensionBinder); ok {
+			binder.bind(c)
+		}
+	}
+}
+
+// 依次使用协商的拓展编码消息, 结果追加写入 dst, 返回帧头需要设置的保留位.
+// 返回 0 表示没有任何拓展处理该消息, 此时 dst 没有被修改.
+// Encodes the message with the negotiated extensions in turn, appends the result to dst,
+// and returns the reserved bits to be set in the frame header.
+// Returns 0 if no extension processed the message, in which case dst is not modified.
+func (c *Conn) encodeMessage(opcode Opcode, payload internal.Payload, dst *bytes.Buffer) (uint8, error) {
+	var rsv uint8
+	var current ExtensionPayload = payload
+	var tmp *bytes.Buffer
+	defer func() { binaryPool.Put(tmp) }()
+
+	var n = len(c.extensions)
+	for i, item := range c.extensions {
+		// 最后一个拓展直接写入 dst, 减少一次拷贝
+		// The last extension writes directly to dst, saving a copy
+		var out = dst
+		if i < n-1 {
+			out = binaryPool.Get(current.Len())
+		}
+		ok, err := item.handler.Encode(c, opcode, current, out)
+		if err != nil || !ok {
+			if out != dst {
+				binaryPool.Put(out)
+			}
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		rsv |= item.rsv
+		if out == dst {
+			return rsv, nil
+		}
+		binaryPool.Put(tmp)
+		tmp = out
+		current = internal.Bytes(out.Bytes())
+	}
+
+	if tmp != nil {
+		_, _ = dst.Write(tmp.Bytes())
+	}
+	return rsv, nil
+}
+
+// 按照与编码相反的顺序解码消息
+// Decodes the message in the reverse order of encoding
+func (c *Conn) decodeMessage(msg *Message) error {
+	var data = msg.Data
+	for i := len(c.extensions) - 1; i >= 0; i-- {
+		var item = c.extensions[i]
+		if msg.rsv&item.rsv == 0 {
+			continue
+		}
+		out, err := item.handler.Decode(c, msg.Opcode, data)
+		if err != nil {
+			return err
+		}
+		if out != data && data != msg.Data {
+			binaryPool.Put(data)
+		}
+		data = out
+	}
+	if data != msg.Data {
+		binaryPool.Put(msg.Data)
+		msg.Data = data
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package gws
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试用拓展, 将消息逐字节异或
+// Extension for testing, XORs the message byte by byte
+type xorExtension struct {
+	key      byte
+	rsv      uint8
+	rejected bool
+}
+
+func (c *xorExtension) Name() string { return "x-xor" }
+
+func (c *xorExtension) RSV() uint8 { return c.rsv }
+
+func (c *xorExtension) Offer() []ExtensionParam {
+	return []ExtensionParam{{Key: "key", Value: string(c.key)}}
+}
+
+func (c *xorExtension) Accept(offer []ExtensionParam) ([]ExtensionParam, ExtensionHandler, error) {
+	if c.rejected || len(offer) != 1 || offer[0].Key != "key" {
+		return nil, nil, errors.New("rejected")
+	}
+	return offer, &xorHandler{key: offer[0].Value[0]}, nil
+}
+
+func (c *xorExtension) Confirm(response []ExtensionParam) (ExtensionHandler, error) {
+	if len(response) != 1 || response[0].Key != "key" {
+		return nil, ErrExtensionNegotiation
+	}
+	return &xorHandler{key: response[0].Value[0]}, nil
+}
+
+type xorHandler struct {
+	key byte
+}
+
+func (c *xorHandler) Encode(socket *Conn, opcode Opcode, payload ExtensionPayload, dst *bytes.Buffer) (bool, error) {
+	var offset = dst.Len()
+	if _, err := payload.WriteTo(dst); err != nil {
+		return false, err
+	}
+	var p = dst.Bytes()[offset:]
+	for i := range p {
+		p[i] ^= c.key
+	}
+	return true, nil
+}
+
+func (c *xorHandler) Decode(socket *Conn, opcode Opcode, payload *bytes.Buffer) (*bytes.Buffer, error) {
+	var p = payload.Bytes()
+	for i := range p {
+		p[i] ^= c.key
+	}
+	return payload, nil
+}
+
+func TestParseExtensions(t *testing.T) {
+	var offers = parseExtensions(`permessage-deflate; client_max_window_bits, x-xor; key="k", x-empty`)
+	assert.Equal(t, 3, len(offers))
+	assert.Equal(t, "permessage-deflate", offers[0].name)
+	assert.Equal(t, []ExtensionParam{{Key: "client_max_window_bits"}}, offers[0].params)
+	assert.Equal(t, []ExtensionParam{{Key: "key", Value: "k"}}, offers[1].params)
+	assert.Equal(t, "x-empty", offers[2].name)
+	assert.Equal(t, 0, len(offers[2].params))
+
+	offers = parseExtensions(`x-a; k="v,1", x b, x-c; =1, x-d; k="v", x-e; k=v"`)
+	assert.Equal(t, 5, len(offers))
+	assert.Error(t, offers[0].err)
+	assert.Error(t, offers[1].err)
+	assert.Error(t, offers[2].err)
+	assert.NoError(t, offers[3].err)
+	assert.Equal(t, []ExtensionParam{{Key: "k", Value: "v"}}, offers[3].params)
+	assert.Error(t, offers[4].err)
+
+	assert.Equal(t, "x-xor; key=k; flag", formatExtension("x-xor", []ExtensionParam{{Key: "key", Value: "k"}, {Key: "flag"}}))
+}
+
+func TestNegotiateExtensions(t *testing.T) {
+	t.Run("client preference", func(t *testing.T) {
+		var ext = &xorExtension{key: 'a', rsv: RSV2}
+		var deflate = NewUpgrader(new(BuiltinEventHandler), &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true}}).extensions[0]
+		header, results := negotiateExtensions("x-xor; key=b, permessage-deflate", []Extension{deflate, ext})
+		assert.Equal(t, "x-xor; key=b, permessage-deflate; server_no_context_takeover; client_no_context_takeover", header)
+		assert.Equal(t, 2, len(results))
+		assert.Equal(t, RSV2, results[0].rsv)
+		assert.Equal(t, RSV1, results[1].rsv)
+	})
+
+	t.Run("rsv conflict", func(t *testing.T) {
+		var ext = &xorExtension{key: 'a', rsv: RSV1}
+		var deflate = NewUpgrader(new(BuiltinEventHandler), &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true}}).extensions[0]
+		header, results := negotiateExtensions("permessage-deflate, x-xor; key=b", []Extension{deflate, ext})
+		assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", header)
+		assert.Equal(t, 1, len(results))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		var ext = &xorExtension{key: 'a', rsv: RSV2, rejected: true}
+		header, results := negotiateExtensions("x-xor; key=b, x-xor; key=c", []Extension{ext})
+		assert.Equal(t, "", header)
+		assert.Equal(t, 0, len(results))
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		var ext = &xorExtension{key: 'a', rsv: RSV2}
+		var extensions = []Extension{ext}
+		assert.Equal(t, "x-xor; key=a", offerExtensions(extensions))
+
+		results, err := confirmExtensions("x-xor; key=a", extensions)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+
+		_, err = confirmExtensions("permessage-deflate", extensions)
+		assert.ErrorIs(t, err, ErrExtensionNegotiation)
+
+		_, err = confirmExtensions("x-xor; key=a, x-xor; key=a", extensions)
+		assert.ErrorIs(t, err, ErrExtensionNegotiation)
+
+		_, err = confirmExtensions("x-xor", extensions)
+		assert.ErrorIs(t, err, ErrExtensionNegotiation)
+	})
+}
+
+func TestCustomExtension(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("with permessage-deflate", func(t *testing.T) {
+		var addr = "127.0.0.1:" + nextPort()
+		var wg = &sync.WaitGroup{}
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var server = NewServer(serverHandler, &ServerOption{
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+			Extensions:        []Extension{&xorExtension{rsv: RSV2}},
+		})
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var payload = internal.AlphabetNumeric.Generate(1024)
+		var messages = make(chan string, 2)
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			messages <- message.Data.String()
+			wg.Done()
+		}
+		client, resp, err := NewClient(clientHandler, &ClientOption{
+			Addr:              "ws://" + addr,
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+			Extensions:        []Extension{&xorExtension{key: 'x', rsv: RSV2}},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal("permessage-deflate; server_no_context_takeover; client_no_context_takeover, x-xor; key=x", resp.Request.Header.Get(internal.SecWebSocketExtensions.Key))
+		as.Equal(RSV1|RSV2, client.rsvMask)
+		go client.ReadLoop()
+
+		wg.Add(2)
+		as.NoError(client.WriteMessage(OpcodeText, payload))
+		as.NoError(client.WriteString("a"))
+		wg.Wait()
+		as.Equal(string(payload), <-messages)
+		as.Equal("a", <-messages)
+	})
+
+	t.Run("unexpected rsv", func(t *testing.T) {
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onClose = func(socket *Conn, err error) {
+			as.ErrorIs(err, internal.CloseProtocolError)
+			wg.Done()
+		}
+		server, client := newPeer(serverHandler, &ServerOption{}, new(webSocketMocker), &ClientOption{})
+		go server.ReadLoop()
+		go client.ReadLoop()
+
+		client.setExtensions([]negotiatedExtension{{rsv: RSV3, handler: &xorHandler{key: 'x'}}})
+		as.NoError(client.WriteString("hello"))
+		wg.Wait()
+	})
+
+	t.Run("handshake fail", func(t *testing.T) {
+		srv, cli := net.Pipe()
+		go func() {
+			var text = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Extensions: x-xor; key=a\r\nSec-WebSocket-Accept: ygR8UkmG67DM75dkgZzwplwlEEo=\r\n\r\n"
+			var buf = make([]byte, 1024)
+			_, _ = srv.Read(buf)
+			_, _ = srv.Write([]byte(text))
+		}()
+		var d = &connector{
+			option:          initClientOption(&ClientOption{}),
+			conn:            cli,
+			secWebsocketKey: "1fTfP/qALD+eAWcU80P0bg==",
+			eventHandler:    new(BuiltinEventHandler),
+		}
+		_, _, err := d.handshake()
+		as.ErrorIs(err, ErrExtensionNegotiation)
+	})
+}
@@ -0,0 +1,142 @@
+package gws
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+)
+
+// 将连接交给 net/http 处理的监听器
+// Listener that hands connections over to net/http
+type handoffListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newHandoffListener(addr net.Addr) *handoffListener {
+	return &handoffListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (c *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	case <-c.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (c *handoffListener) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *handoffListener) Addr() net.Addr { return c.addr }
+
+// 投递连接, 监听器关闭后返回 false
+// Delivers the connection, returns false after the listener is closed
+func (c *handoffListener) deliver(conn net.Conn) bool {
+	select {
+	case c.conns <- conn:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// 是否为 WebSocket 升级请求, HTTP/2 使用扩展 CONNECT
+// Whether it is a WebSocket upgrade request, HTTP/2 uses extended CONNECT
+func isUpgradeRequest(r *http.Request) bool {
+	if isHTTP2Request(r) {
+		return r.Method == http.MethodConnect && r.Header.Get(pseudoProtocol) != ""
+	}
+	return strings.EqualFold(r.Header.Get(internal.Upgrade.Key), internal.Upgrade.Val)
+}
+
+// 创建处理 HTTP/2 和普通 HTTP 请求的 net/http 服务器
+// Creates the net/http server handling HTTP/2 and plain HTTP requests
+func (c *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           http.HandlerFunc(c.serveHTTP),
+		ReadHeaderTimeout: c.option.HandshakeReadTimeout,
+		MaxHeaderBytes:    c.option.MaxHeaderBytes,
+		ErrorLog:          log.New(loggerWriter{c.option.Logger}, "", 0),
+		ConnContext:       withProxyHeader,
+	}
+}
+
+// 判断是否需要把连接交给 net/http 处理, 需要时投递连接并返回 true
+// ALPN 协商为 h2 的连接, 以及设置了 FallbackHandler 时的全部连接都交给 net/http 处理.
+// Determines whether the connection should be handed over to net/http, delivers it and returns true if so
+// Connections negotiating h2 via ALPN, and all connections when FallbackHandler is set, are handled by net/http.
+func (c *Server) handoff(conn net.Conn, listener *handoffListener) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok && c.option.HTTP2Enabled {
+		_ = conn.SetDeadline(time.Now().Add(c.option.HandshakeReadTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			c.OnError(conn, err)
+			_ = conn.Close()
+			return true
+		}
+		_ = conn.SetDeadline(time.Time{})
+		if tlsConn.ConnectionState().NegotiatedProtocol != alpnHTTP2 && c.option.FallbackHandler == nil {
+			return false
+		}
+	} else if c.option.FallbackHandler == nil {
+		return false
+	}
+	if !listener.deliver(conn) {
+		_ = conn.Close()
+	}
+	return true
+}
+
+// 处理 net/http 转交的请求, 升级请求进入 WebSocket 流程, 其他请求交给 FallbackHandler
+// Handles requests passed by net/http, upgrade requests go through the WebSocket flow,
+// other requests are handled by FallbackHandler
+func (c *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case c.option.FallbackHandler != nil && !isUpgradeRequest(r):
+		c.option.FallbackHandler.ServeHTTP(w, r)
+	case isHTTP2Request(r):
+		// 流的生命周期与 Handler 绑定, 阻塞到 WebSocket 连接关闭
+		// The lifetime of the stream is bound to the Handler, blocks until the WebSocket connection is closed
+		// 流没有对应的 net.Conn, 错误只记录日志
+		// Streams have no corresponding net.Conn, errors are only logged
+		upgrader, err := c.match(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			c.option.Logger.Error("gws: " + err.Error())
+			return
+		}
+		socket, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			c.option.Logger.Error("gws: " + err.Error())
+			return
+		}
+		socket.ReadLoop()
+	default:
+		conn, br, err := c.upgrader.hijack(w)
+		if err != nil {
+			c.option.Logger.Error("gws: " + err.Error())
+			return
+		}
+		c.OnRequest(conn, br, r)
+	}
+}
+
+// 将 net/http 的错误日志转发到 Logger
+// Forwards the error logs of net/http to Logger
+type loggerWriter struct{ logger Logger }
+
+func (c loggerWriter) Write(p []byte) (int, error) {
+	c.logger.Error(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,101 @@
+package gws
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackHandler(t *testing.T) {
+	var as = assert.New(t)
+
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	var serverHandler = new(webSocketMocker)
+	serverHandler.onMessage = func(socket *Conn, message *Message) {
+		_ = socket.WriteMessage(message.Opcode, message.Bytes())
+	}
+	var server = NewServer(serverHandler, &ServerOption{FallbackHandler: mux})
+	var requests = int64(0)
+	var onRequest = server.OnRequest
+	server.OnRequest = func(conn net.Conn, br *bufio.Reader, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		onRequest(conn, br, r)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+	if !as.NoError(err) {
+		return
+	}
+	var counter = &countingListener{Listener: listener}
+	go server.RunListener(counter)
+	var addr = listener.Addr().String()
+
+	t.Run("keep-alive", func(t *testing.T) {
+		var client = &http.Client{Transport: &http.Transport{}}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get("http://" + addr + "/health")
+			if !as.NoError(err) {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			as.Equal(http.StatusOK, resp.StatusCode)
+			as.Equal("ok", string(body))
+		}
+		resp, err := client.Get("http://" + addr + "/none")
+		if as.NoError(err) {
+			as.Equal(http.StatusNotFound, resp.StatusCode)
+			_ = resp.Body.Close()
+		}
+		as.Equal(int64(1), atomic.LoadInt64(&counter.num))
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			as.Equal("hello", message.Data.String())
+			wg.Done()
+		}
+		client, _, err := NewClient(clientHandler, &ClientOption{Addr: "ws://" + addr + "/connect"})
+		if !as.NoError(err) {
+			return
+		}
+		go client.ReadLoop()
+		as.NoError(client.WriteString("hello"))
+		wg.Wait()
+		as.Equal(int64(1), atomic.LoadInt64(&requests))
+
+		// 不完整的升级请求仍然由 WebSocket 流程拒绝
+		// Incomplete upgrade requests are still rejected by the WebSocket flow
+		request, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/connect", nil)
+		request.Header.Set("Upgrade", "websocket")
+		resp, err := http.DefaultClient.Do(request)
+		if as.NoError(err) {
+			as.Equal(http.StatusBadRequest, resp.StatusCode)
+			_ = resp.Body.Close()
+		}
+		as.Equal(int64(2), atomic.LoadInt64(&requests))
+	})
+
+	t.Run("is upgrade request", func(t *testing.T) {
+		var r = &http.Request{Header: http.Header{}, ProtoMajor: 1}
+		as.False(isUpgradeRequest(r))
+		r.Header.Set("Upgrade", "WebSocket")
+		as.True(isUpgradeRequest(r))
+
+		r = &http.Request{Header: http.Header{}, ProtoMajor: 2, Method: http.MethodConnect}
+		as.False(isUpgradeRequest(r))
+		r.Header.Set(pseudoProtocol, "websocket")
+		as.True(isUpgradeRequest(r))
+	})
+}
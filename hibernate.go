@@ -0,0 +1,170 @@
+package gws
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// 休眠状态
+	// Hibernation state
+	hibernation struct {
+		// 用户设置的读截止时间, UnixNano, 0 表示没有截止时间
+		// Read deadline set by the user, UnixNano, 0 means no deadline
+		deadline int64
+
+		// 是否处于休眠状态
+		// Whether it is hibernating
+		sleeping uint32
+
+		// 休眠期间读到的第一个字节会暂存在这里
+		// The first byte read during hibernation is staged here
+		reader hibernateReader
+	}
+
+	// 先返回暂存的字节, 再从底层连接读取
+	// Returns the staged byte first, then reads from the underlying connection
+	hibernateReader struct {
+		conn net.Conn
+		b    [1]byte
+		n    int
+	}
+
+	// MemoryStats 连接级别的内存占用, 单位字节. 服务端共享的压缩器和内存池不计入.
+	// Connection-level memory footprint in bytes. Compressors and memory pools shared by the server are not counted.
+	MemoryStats struct {
+		// 是否处于休眠状态
+		// Whether the connection is hibernating
+		Hibernating bool
+
+		// 读缓冲区
+		// Read buffer
+		ReadBuffer int
+
+		// 压缩和解压字典的滑动窗口
+		// Sliding windows of the compression and decompression dictionaries
+		SlideWindow int
+
+		// 连接独占的压缩器, 包含估算值
+		// Compressor exclusive to the connection, including estimates
+		Deflater int
+	}
+)
+
+func (c *hibernateReader) Read(p []byte) (int, error) {
+	if c.n > 0 && len(p) > 0 {
+		p[0] = c.b[0]
+		c.n = 0
+		return 1, nil
+	}
+	return c.conn.Read(p)
+}
+
+// Total 总的内存占用
+// Total memory footprint
+func (c MemoryStats) Total() int { return c.ReadBuffer + c.SlideWindow + c.Deflater }
+
+// 保存用户设置的读截止时间
+// Saves the read deadline set by the user
+func (c *Conn) storeReadDeadline(t time.Time) {
+	var v int64
+	if !t.IsZero() {
+		v = t.UnixNano()
+	}
+	atomic.StoreInt64(&c.hibernation.deadline, v)
+}
+
+// 获取用户设置的读截止时间
+// Gets the read deadline set by the user
+func (c *Conn) loadReadDeadline() time.Time {
+	if v := atomic.LoadInt64(&c.hibernation.deadline); v != 0 {
+		return time.Unix(0, v)
+	}
+	return time.Time{}
+}
+
+// 等待数据到达, 空闲超过 HibernateTimeout 后进入休眠
+// Waits for data to arrive, and hibernates after being idle for longer than HibernateTimeout
+func (c *Conn) waitData() error {
+	var timeout = c.config.HibernateTimeout
+	if timeout <= 0 || c.br.Buffered() > 0 {
+		return nil
+	}
+
+	// 用户设置的截止时间更早到达时, 没有必要休眠
+	// No need to hibernate if the deadline set by the user arrives earlier
+	var deadline = c.loadReadDeadline()
+	var wakeAt = time.Now().Add(timeout)
+	if !deadline.IsZero() && deadline.Before(wakeAt) {
+		return nil
+	}
+
+	if err := c.conn.SetReadDeadline(wakeAt); err != nil {
+		// 不支持截止时间的连接(例如 HTTP/2 客户端的流)不会休眠
+		// Connections that do not support deadlines (e.g. HTTP/2 client streams) do not hibernate
+		if isNoDeadline(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := c.br.Peek(1); !errors.Is(err, os.ErrDeadlineExceeded) {
+		if err != nil {
+			return err
+		}
+		return c.conn.SetReadDeadline(c.loadReadDeadline())
+	}
+	return c.hibernate()
+}
+
+// 释放读缓冲区和独占的压缩器, 阻塞到数据到达后重新获取
+// Releases the read buffer and the exclusive compressor, blocks until data arrives and reacquires them
+func (c *Conn) hibernate() error {
+	if c.isServer {
+		c.br.Reset(nil)
+		c.config.brPool.Put(c.br)
+	}
+	c.br = nil
+	if c.pd.Enabled && !c.deflater.shared {
+		c.deflater.release()
+	}
+	atomic.StoreUint32(&c.hibernation.sleeping, 1)
+
+	var reader = &c.hibernation.reader
+	reader.conn = c.conn
+	err := c.conn.SetReadDeadline(c.loadReadDeadline())
+	if err == nil {
+		reader.n, err = c.conn.Read(reader.b[:])
+	}
+
+	atomic.StoreUint32(&c.hibernation.sleeping, 0)
+	if c.isServer {
+		c.br = c.config.brPool.Get()
+	} else {
+		c.br = bufio.NewReaderSize(nil, c.config.ReadBufferSize)
+	}
+	c.br.Reset(reader)
+	return err
+}
+
+// MemoryStats 获取连接级别的内存占用
+// Gets the connection-level memory footprint
+func (c *Conn) MemoryStats() MemoryStats {
+	var stats = MemoryStats{Hibernating: atomic.LoadUint32(&c.hibernation.sleeping) == 1}
+	if !stats.Hibernating {
+		stats.ReadBuffer = c.config.ReadBufferSize
+	}
+	if c.cpsWindow.enabled {
+		stats.SlideWindow += c.cpsWindow.size
+	}
+	if c.dpsWindow.enabled {
+		stats.SlideWindow += c.dpsWindow.size
+	}
+	if c.pd.Enabled && !c.deflater.shared {
+		stats.Deflater = c.deflater.memoryUsage()
+	}
+	return stats
+}
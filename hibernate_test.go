@@ -0,0 +1,107 @@
+package gws
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHibernate(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("wake up", func(t *testing.T) {
+		var addr = "127.0.0.1:" + nextPort()
+		var sockets = make(chan *Conn, 1)
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onOpen = func(socket *Conn) { sockets <- socket }
+		serverHandler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var server = NewServer(serverHandler, &ServerOption{
+			HibernateTimeout:  50 * time.Millisecond,
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+		})
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var wg = &sync.WaitGroup{}
+		var messages = make(chan string, 4)
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			messages <- message.Data.String()
+			wg.Done()
+		}
+		client, _, err := NewClient(clientHandler, &ClientOption{
+			Addr:              "ws://" + addr,
+			HibernateTimeout:  50 * time.Millisecond,
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		go client.ReadLoop()
+		var socket = <-sockets
+
+		var stats = client.MemoryStats()
+		as.False(stats.Hibernating)
+		as.Equal(defaultReadBufferSize, stats.ReadBuffer)
+		as.Greater(stats.Deflater, 0)
+		// 服务端的压缩器被多个连接共享, 不计入连接的内存占用
+		as.Equal(0, socket.MemoryStats().Deflater)
+
+		time.Sleep(200 * time.Millisecond)
+		stats = client.MemoryStats()
+		as.True(stats.Hibernating)
+		as.Equal(0, stats.Total())
+		as.True(socket.MemoryStats().Hibernating)
+		as.Equal(0, socket.MemoryStats().Total())
+		// 共享的压缩器不随连接休眠释放
+		as.Greater(socket.deflater.memoryUsage(), 0)
+
+		for i := 0; i < 2; i++ {
+			var payload = string(internal.AlphabetNumeric.Generate(1024))
+			wg.Add(1)
+			as.NoError(client.WriteString(payload))
+			wg.Wait()
+			as.Equal(payload, <-messages)
+			time.Sleep(200 * time.Millisecond)
+		}
+		as.True(client.MemoryStats().Hibernating)
+	})
+
+	t.Run("read deadline", func(t *testing.T) {
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onOpen = func(socket *Conn) {
+			_ = socket.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		}
+		serverHandler.onClose = func(socket *Conn, err error) {
+			as.True(errors.Is(err, os.ErrDeadlineExceeded))
+			wg.Done()
+		}
+		server, client := newPeer(serverHandler, &ServerOption{HibernateTimeout: 50 * time.Millisecond}, new(webSocketMocker), &ClientOption{})
+		go server.ReadLoop()
+		go client.ReadLoop()
+		wg.Wait()
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		srv, cli := net.Pipe()
+		go func() { _, _ = srv.Write([]byte("bcd")) }()
+		var reader = &hibernateReader{conn: cli, b: [1]byte{'a'}, n: 1}
+		var p = make([]byte, 4)
+		n, err := reader.Read(p)
+		as.NoError(err)
+		as.Equal("a", string(p[:n]))
+		n, err = reader.Read(p)
+		as.NoError(err)
+		as.Equal("bcd", string(p[:n]))
+	})
+}
@@ -0,0 +1,264 @@
+package gws
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+)
+
+const (
+	// HTTP/2 扩展 CONNECT 的伪头部
+	// Pseudo header of HTTP/2 extended CONNECT
+	pseudoProtocol = ":protocol"
+
+	// ALPN 协议标识
+	// ALPN protocol identifiers
+	alpnHTTP2  = "h2"
+	alpnHTTP11 = "http/1.1"
+)
+
+type (
+	// 基于 HTTP/2 流实现的 net.Conn, WebSocket 帧在流的请求体和响应体中传输
+	// net.Conn implemented on top of an HTTP/2 stream, WebSocket frames are carried in the request and response bodies
+	http2Stream struct {
+		reader io.ReadCloser
+		writer io.Writer
+
+		// 服务端用于刷新响应和设置截止时间, 客户端为 nil
+		// Used by the server to flush the response and set deadlines, nil on the client
+		rc *http.ResponseController
+
+		// 关闭时释放的资源
+		// Resources released on close
+		release func()
+
+		once       sync.Once
+		localAddr  net.Addr
+		remoteAddr net.Addr
+	}
+
+	// HTTP/2 流的地址
+	// Address of an HTTP/2 stream
+	http2Addr string
+)
+
+func (c http2Addr) Network() string { return "tcp" }
+
+func (c http2Addr) String() string { return string(c) }
+
+func (c *http2Stream) Read(p []byte) (int, error) { return c.reader.Read(p) }
+
+func (c *http2Stream) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	if err == nil && c.rc != nil {
+		err = c.rc.Flush()
+	}
+	return n, err
+}
+
+func (c *http2Stream) Close() error {
+	var err = net.ErrClosed
+	c.once.Do(func() {
+		err = c.reader.Close()
+		if c.release != nil {
+			c.release()
+		}
+	})
+	return err
+}
+
+func (c *http2Stream) LocalAddr() net.Addr { return c.localAddr }
+
+func (c *http2Stream) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *http2Stream) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// 客户端的流不支持截止时间
+// Deadlines are not supported by client streams
+func (c *http2Stream) SetReadDeadline(t time.Time) error {
+	if c.rc == nil {
+		return internal.SelectValue(t.IsZero(), nil, os.ErrNoDeadline)
+	}
+	return c.rc.SetReadDeadline(t)
+}
+
+func (c *http2Stream) SetWriteDeadline(t time.Time) error {
+	if c.rc == nil {
+		return internal.SelectValue(t.IsZero(), nil, os.ErrNoDeadline)
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+// 是否为 HTTP/2 的请求
+// Whether it is an HTTP/2 request
+func isHTTP2Request(r *http.Request) bool { return r.ProtoMajor == 2 }
+
+// 通过 HTTP/2 扩展 CONNECT (RFC 8441) 升级到 WebSocket 连接
+// 注意: 流的生命周期与 http.Handler 绑定, Handler 返回后流就会关闭, 所以需要在 Handler 中阻塞调用 ReadLoop.
+// Upgrades to a WebSocket connection via HTTP/2 extended CONNECT (RFC 8441)
+// Note: the lifetime of the stream is bound to the http.Handler, the stream is closed after the Handler returns,
+// so ReadLoop must be called in the Handler in a blocking way.
+func (c *Upgrader) upgradeHTTP2(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	socket, err := c.doUpgradeHTTP2(w, r)
+	if err != nil && !isUpgradedError(err) {
+		var e, status, body = toRejection(err)
+		var header = w.Header()
+		for k, v := range e.Header {
+			if !rejectionExcludeHeaders[http.CanonicalHeaderKey(k)] {
+				header[http.CanonicalHeaderKey(k)] = v
+			}
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}
+	return socket, err
+}
+
+func (c *Upgrader) doUpgradeHTTP2(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	var ip = remoteHost(http2Addr(r.RemoteAddr))
+	if err := c.admission.acquire(ip); err != nil {
+		return nil, c.rejectHTTP2(w, err)
+	}
+	socket, err := c.handshakeHTTP2(w, r)
+	if err != nil {
+		c.admission.release(ip)
+		return nil, err
+	}
+	socket.release = func() { c.admission.release(ip) }
+	return socket, nil
+}
+
+func (c *Upgrader) handshakeHTTP2(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	session, err := c.checkRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method != http.MethodConnect || !strings.EqualFold(r.Header.Get(pseudoProtocol), internal.Upgrade.Val) {
+		return nil, ErrHandshake
+	}
+
+	n, err := c.negotiate(r, session)
+	if err != nil {
+		return nil, err
+	}
+
+	var header = w.Header()
+	if n.extensionHeader != "" {
+		header.Set(internal.SecWebSocketExtensions.Key, n.extensionHeader)
+	}
+	if n.subprotocol != "" {
+		header.Set(internal.SecWebSocketProtocol.Key, n.subprotocol)
+	}
+	copyResponseHeader(header, c.option.ResponseHeader)
+	copyResponseHeader(header, n.header)
+
+	var rc = http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	var stream = &http2Stream{reader: r.Body, writer: w, rc: rc, remoteAddr: http2Addr(r.RemoteAddr)}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		stream.localAddr = addr
+	} else {
+		stream.localAddr = http2Addr("")
+	}
+	var br = c.option.config.brPool.Get()
+	br.Reset(stream)
+	return c.newConn(stream, br, r, session, n), nil
+}
+
+// 复制额外的响应头, 受保护的和非法的字段会被忽略
+// Copies extra response headers, protected and invalid fields are ignored
+func copyResponseHeader(dst, src http.Header) {
+	for k, values := range src {
+		if !internal.IsToken(k) || protectedHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for _, v := range values {
+			dst.Add(k, headerValueReplacer.Replace(v))
+		}
+	}
+}
+
+// 通过 HTTP/2 扩展 CONNECT (RFC 8441) 执行 WebSocket 握手, 共享同一个 Transport 的连接复用 TLS 连接
+// Performs the WebSocket handshake via HTTP/2 extended CONNECT (RFC 8441),
+// connections sharing the same Transport reuse the TLS connection
+func (c *connector) handshakeHTTP2(URL *url.URL) (*Conn, *http.Response, error) {
+	var u = *URL
+	u.Scheme = internal.SelectValue(URL.Scheme == "wss", "https", "http")
+
+	// 握手成功后上下文一直保持到连接关闭, 取消上下文会重置流
+	// After a successful handshake the context is kept until the connection is closed, canceling it resets the stream
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	var release = func() {
+		_ = pw.Close()
+		cancel()
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodConnect, u.String(), pr)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	c.setRequestHeader(r)
+	r.Header.Del("Host")
+	r.Header.Set(pseudoProtocol, internal.Upgrade.Val)
+
+	var timer = time.AfterFunc(c.option.HandshakeTimeout, cancel)
+	var stop = afterFunc(c.ctx, cancel)
+	resp, err := c.option.HTTP2Transport.RoundTrip(r)
+	if !stop() {
+		err = c.ctx.Err()
+	} else if !timer.Stop() && err == nil {
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		release()
+		return nil, resp, err
+	}
+
+	var stream = &http2Stream{
+		reader:     resp.Body,
+		writer:     pw,
+		release:    release,
+		localAddr:  http2Addr(""),
+		remoteAddr: http2Addr(u.Host),
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = stream.Close()
+		return nil, resp, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	subprotocol, extensions, err := c.confirm(resp)
+	if err != nil {
+		_ = stream.Close()
+		return nil, resp, err
+	}
+	var br = bufio.NewReaderSize(stream, c.option.ReadBufferSize)
+	return c.newConn(stream, br, subprotocol, extensions), resp, nil
+}
+
+// 是否为不支持截止时间的错误
+// Whether it is an error of unsupported deadlines
+func isNoDeadline(err error) bool { return errors.Is(err, os.ErrNoDeadline) }
@@ -0,0 +1,200 @@
+package gws
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingListener struct {
+	net.Listener
+	num int64
+}
+
+func (c *countingListener) Accept() (net.Conn, error) {
+	conn, err := c.Listener.Accept()
+	if err == nil {
+		atomic.AddInt64(&c.num, 1)
+	}
+	return conn, err
+}
+
+// 在内存中模拟 HTTP/2 扩展 CONNECT 的 Transport, 请求体和响应体都是管道
+// Transport simulating HTTP/2 extended CONNECT in memory, both the request and response bodies are pipes
+type pipeTransport struct {
+	upgrader *Upgrader
+}
+
+type pipeResponseWriter struct {
+	header http.Header
+	status chan *http.Response
+	writer *io.PipeWriter
+	reader *io.PipeReader
+}
+
+func (c *pipeResponseWriter) Header() http.Header { return c.header }
+
+func (c *pipeResponseWriter) WriteHeader(statusCode int) {
+	c.status <- &http.Response{
+		StatusCode: statusCode,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     c.header.Clone(),
+		Body:       c.reader,
+	}
+}
+
+func (c *pipeResponseWriter) Write(p []byte) (int, error) { return c.writer.Write(p) }
+
+func (c *pipeResponseWriter) Flush() {}
+
+func (c *pipeResponseWriter) SetReadDeadline(t time.Time) error { return nil }
+
+func (c *pipeResponseWriter) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *pipeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var request = r.Clone(r.Context())
+	request.Proto, request.ProtoMajor, request.ProtoMinor = "HTTP/2.0", 2, 0
+	request.RemoteAddr = "127.0.0.1:10086"
+	request.Body = r.Body
+
+	pr, pw := io.Pipe()
+	var w = &pipeResponseWriter{header: http.Header{}, status: make(chan *http.Response, 1), writer: pw, reader: pr}
+	go func() {
+		if socket, err := c.upgrader.Upgrade(w, request); err == nil {
+			socket.ReadLoop()
+		}
+		_ = pw.Close()
+	}()
+	return <-w.status, nil
+}
+
+func TestHTTP2(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("extended connect", func(t *testing.T) {
+		var sockets = make(chan *Conn, 1)
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onOpen = func(socket *Conn) { sockets <- socket }
+		serverHandler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var upgrader = NewUpgrader(serverHandler, &ServerOption{
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+			SubProtocols:      []string{"chat"},
+			ResponseHeader:    http.Header{"X-Server": []string{"gws"}},
+			Authorize: func(r *http.Request, session SessionStorage) bool {
+				return r.URL.Query().Get("token") != "bad"
+			},
+		})
+		var transport = &pipeTransport{upgrader: upgrader}
+
+		var wg = &sync.WaitGroup{}
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			as.Equal("hello", message.Data.String())
+			wg.Done()
+		}
+		client, resp, err := NewClient(clientHandler, &ClientOption{
+			Addr:              "wss://example.com/connect",
+			HTTP2Transport:    transport,
+			PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+			SubProtocols:      []string{"chat"},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal(http.StatusOK, resp.StatusCode)
+		as.Equal("gws", resp.Header.Get("X-Server"))
+		as.Equal("chat", client.SubProtocol())
+		as.Equal(RSV1, client.rsvMask)
+		as.Equal("example.com", client.RemoteAddr().String())
+		go client.ReadLoop()
+
+		var socket = <-sockets
+		as.Equal("127.0.0.1:10086", socket.RemoteAddr().String())
+		as.Equal("chat", socket.SubProtocol())
+
+		wg.Add(2)
+		as.NoError(client.WriteString("hello"))
+		as.NoError(client.WriteString("hello"))
+		wg.Wait()
+		as.NoError(client.WriteClose(1000, nil))
+
+		_, resp, err = NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:           "wss://example.com/connect?token=bad",
+			HTTP2Transport: transport,
+			SubProtocols:   []string{"chat"},
+		})
+		as.Error(err)
+		as.Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("alpn", func(t *testing.T) {
+		var dir = os.Getenv("PWD")
+		cert, err := tls.LoadX509KeyPair(dir+"/examples/wss/cert/server.crt", dir+"/examples/wss/cert/server.pem")
+		if !as.NoError(err) {
+			return
+		}
+		var logger = new(countingLogger)
+		var server = NewServer(new(BuiltinEventHandler), &ServerOption{HTTP2Enabled: true, Logger: logger})
+		listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+		if !as.NoError(err) {
+			return
+		}
+		var counter = &countingListener{Listener: listener}
+		go server.RunListener(tls.NewListener(counter, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{alpnHTTP2, alpnHTTP11},
+		}))
+
+		// 普通的 HTTP/2 请求被拒绝, 所有请求复用一个 TLS 连接
+		// Plain HTTP/2 requests are rejected, all requests reuse one TLS connection
+		var httpClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		for i := 0; i < 3; i++ {
+			resp, err := httpClient.Get("https://" + listener.Addr().String())
+			if !as.NoError(err) {
+				return
+			}
+			as.Equal(2, resp.ProtoMajor)
+			as.Equal(http.StatusBadRequest, resp.StatusCode)
+			_ = resp.Body.Close()
+		}
+		as.Equal(int64(1), atomic.LoadInt64(&counter.num))
+		as.Equal(int64(3), atomic.LoadInt64(&logger.count))
+
+		// 不协商 ALPN 的客户端仍然使用 HTTP/1.1
+		// Clients that do not negotiate ALPN still use HTTP/1.1
+		client, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:      "wss://" + listener.Addr().String(),
+			TlsConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal(1, resp.ProtoMajor)
+		_ = client.NetConn().Close()
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var stream = &http2Stream{reader: http.NoBody, localAddr: http2Addr(""), remoteAddr: http2Addr("127.0.0.1:80")}
+		as.NoError(stream.SetDeadline(time.Time{}))
+		as.ErrorIs(stream.SetReadDeadline(time.Now()), os.ErrNoDeadline)
+		as.ErrorIs(stream.SetWriteDeadline(time.Now()), os.ErrNoDeadline)
+		as.Equal("127.0.0.1:80", stream.RemoteAddr().String())
+		as.Equal("tcp", stream.LocalAddr().Network())
+		as.NoError(stream.Close())
+		as.ErrorIs(stream.Close(), net.ErrClosed)
+	})
+}
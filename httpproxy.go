@@ -0,0 +1,140 @@
+package gws
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	// ProxyError HTTP 代理服务器拒绝了 CONNECT 请求
+	// The HTTP proxy server refused the CONNECT request
+	ProxyError struct {
+		// 代理服务器地址
+		// Address of the proxy server
+		Proxy string
+
+		// 代理服务器响应的状态码, 例如 407 表示需要认证
+		// Status code responded by the proxy server, e.g. 407 means authentication is required
+		StatusCode int
+
+		// 代理服务器响应的响应头
+		// Response headers from the proxy server
+		Header http.Header
+	}
+
+	// 通过 CONNECT 建立的隧道, 先读取解析响应时多读取的数据
+	// Tunnel established via CONNECT, the data read ahead while parsing the response is read first
+	tunnelConn struct {
+		net.Conn
+		br *bufio.Reader
+	}
+)
+
+// Error 代理拒绝的描述
+// Returns a description of the proxy refusal
+func (c *ProxyError) Error() string {
+	return fmt.Sprintf("gws: proxy %s refused connection, status=%d", c.Proxy, c.StatusCode)
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	if c.br.Buffered() > 0 {
+		return c.br.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// 获取连接使用的代理地址, 不使用代理时返回 nil
+// Gets the proxy URL used by the connection, returns nil if no proxy is used
+func (c *connector) proxyURL(URL *url.URL) (*url.URL, error) {
+	if c.option.Proxy == nil {
+		return nil, nil
+	}
+	// 按照 http.ProxyFromEnvironment 的约定, ws 对应 HTTP_PROXY, wss 对应 HTTPS_PROXY
+	// Following the convention of http.ProxyFromEnvironment, ws maps to HTTP_PROXY and wss maps to HTTPS_PROXY
+	var u = *URL
+	u.Scheme = map[string]string{"ws": "http", "wss": "https"}[URL.Scheme]
+	return c.option.Proxy(&http.Request{Method: http.MethodConnect, URL: &u, Host: u.Host, Header: http.Header{}})
+}
+
+// 连接 HTTP(S) 代理服务器并通过 CONNECT 建立到 addr 的隧道
+// Connects to the HTTP(S) proxy server and establishes a tunnel to addr via CONNECT
+func dialProxy(ctx context.Context, dialer Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, ErrUnsupportedProtocol
+	}
+	var proxyAddr = proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), map[string]string{"http": "80", "https": "443"}[proxyURL.Scheme])
+	}
+
+	conn, err := dialContext(ctx, dialer, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		var tlsConn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	tunnel, err := connectTunnel(ctx, conn, proxyURL, addr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// 发送 CONNECT 请求并检查代理服务器的响应
+// Sends the CONNECT request and checks the response of the proxy server
+func connectTunnel(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var r = &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := proxyURL.User; user != nil {
+		var password, _ = user.Password()
+		var credential = base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		r.Header.Set("Proxy-Authorization", "Basic "+credential)
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	var stop = afterFunc(ctx, func() { _ = conn.SetDeadline(aLongTimeAgo) })
+
+	var resp *http.Response
+	var br = bufio.NewReader(conn)
+	var err = r.Write(conn)
+	if err == nil {
+		resp, err = http.ReadResponse(br, r)
+	}
+	if !stop() || (err != nil && ctx.Err() != nil) {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProxyError{Proxy: proxyURL.Redacted(), StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		return &tunnelConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
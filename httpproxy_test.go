@@ -0,0 +1,192 @@
+package gws
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试用的 HTTP CONNECT 代理, 忽略请求的地址, 总是连接到 target. authorization 不为空时检查认证信息.
+// HTTP CONNECT proxy for testing, ignores the requested address and always connects to target.
+// The credentials are checked if authorization is not empty.
+func newTestProxy(target, authorization string) (net.Listener, chan *http.Request) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	var requests = make(chan *http.Request, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var br = bufio.NewReader(conn)
+				r, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				requests <- r
+				if authorization != "" && r.Header.Get("Proxy-Authorization") != authorization {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				backend, err := net.Dial("tcp", target)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				defer backend.Close()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() { _, _ = io.Copy(backend, br) }()
+				_, _ = io.Copy(conn, backend)
+			}(conn)
+		}
+	}()
+	return listener, requests
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var as = assert.New(t)
+	var dir = t.TempDir()
+
+	var handler = new(webSocketMocker)
+	handler.onMessage = func(socket *Conn, message *Message) {
+		_ = socket.WriteMessage(message.Opcode, message.Bytes())
+	}
+
+	var echo = func(client *Conn) {
+		var messages = make(chan string, 1)
+		client.handler = &webSocketMocker{onMessage: func(socket *Conn, message *Message) {
+			messages <- message.Data.String()
+		}}
+		go client.ReadLoop()
+		as.NoError(client.WriteString("hello"))
+		select {
+		case msg := <-messages:
+			as.Equal("hello", msg)
+		case <-time.After(time.Second):
+			as.Fail("timeout")
+		}
+	}
+
+	var addr = "127.0.0.1:" + nextPort()
+	go NewServer(handler, nil).Run(addr)
+
+	_, _, certPEM, keyPEM := newTestCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+	}, nil, nil)
+	var certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	_ = os.WriteFile(certFile, certPEM, 0600)
+	_ = os.WriteFile(keyFile, keyPEM, 0600)
+	var pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	var tlsAddr = "127.0.0.1:" + nextPort()
+	go NewServer(handler, nil).RunTLS(tlsAddr, certFile, keyFile)
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("basic auth", func(t *testing.T) {
+		listener, requests := newTestProxy(addr, "Basic dXNlcjpwYXNz")
+		defer listener.Close()
+		proxyURL, _ := url.Parse("http://user:pass@" + listener.Addr().String())
+
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://example.test/connect",
+			Proxy: http.ProxyURL(proxyURL),
+		})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		var r = <-requests
+		as.Equal(http.MethodConnect, r.Method)
+		as.Equal("example.test:80", r.Host)
+		echo(client)
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		listener, _ := newTestProxy(addr, "Basic dXNlcjpwYXNz")
+		defer listener.Close()
+		proxyURL, _ := url.Parse("http://user:secret@" + listener.Addr().String())
+
+		_, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://example.test/connect",
+			Proxy: http.ProxyURL(proxyURL),
+		})
+		var proxyErr *ProxyError
+		if as.True(errors.As(err, &proxyErr)) {
+			as.Equal(http.StatusProxyAuthRequired, proxyErr.StatusCode)
+			as.Equal("Basic", proxyErr.Header.Get("Proxy-Authenticate"))
+			as.NotContains(proxyErr.Error(), "secret")
+		}
+	})
+
+	t.Run("wss", func(t *testing.T) {
+		listener, requests := newTestProxy(tlsAddr, "")
+		defer listener.Close()
+		proxyURL, _ := url.Parse("http://" + listener.Addr().String())
+
+		var schemes []string
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:      "wss://example.test/connect",
+			TlsConfig: &tls.Config{RootCAs: pool},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				schemes = append(schemes, r.URL.Scheme)
+				return proxyURL, nil
+			},
+		})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal([]string{"https"}, schemes)
+		as.Equal("example.test:443", (<-requests).Host)
+		as.Equal("example.test", client.TLSState().PeerCertificates[0].Subject.CommonName)
+		echo(client)
+	})
+
+	t.Run("no proxy", func(t *testing.T) {
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://" + addr,
+			Proxy: func(r *http.Request) (*url.URL, error) { return nil, nil },
+		})
+		if as.NoError(err) {
+			_ = client.NetConn().Close()
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var proxyErr = errors.New("proxy error")
+		_, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://" + addr,
+			Proxy: func(r *http.Request) (*url.URL, error) { return nil, proxyErr },
+		})
+		as.ErrorIs(err, proxyErr)
+
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://" + addr,
+			Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"}),
+		})
+		as.ErrorIs(err, ErrUnsupportedProtocol)
+
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:  "ws://" + addr,
+			Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:" + nextPort()}),
+		})
+		as.Error(err)
+	})
+}
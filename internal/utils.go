@@ -157,6 +157,80 @@ func Split(s string, sep string) []string {
 	return list[:j]
 }
 
+// SplitQuoted 使用 sep 分割字符串 s, 忽略引号内的分隔符. 空值将会被过滤掉.
+// Splits the string s using sep, ignoring separators inside quotes. Empty values will be filtered out.
+func SplitQuoted(s string, sep byte) []string {
+	var list []string
+	var quoted, escaped = false, false
+	var start = 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			var ch = s[i]
+			switch {
+			case escaped:
+				escaped = false
+				continue
+			case quoted && ch == '\\':
+				escaped = true
+				continue
+			case ch == '"':
+				quoted = !quoted
+				continue
+			case quoted || ch != sep:
+				continue
+			}
+		}
+		if v := strings.TrimSpace(s[start:i]); v != "" {
+			list = append(list, v)
+		}
+		start = i + 1
+	}
+	return list
+}
+
+// IsToken 检查 s 是否为 RFC 7230 定义的 token
+// Checks if s is a token as defined in RFC 7230
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		var ch = s[i]
+		if ch <= ' ' || ch >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, ch) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Unquote 去除 quoted-string 的引号和转义字符, 格式错误时返回 false
+// Removes the quotes and escape characters of a quoted-string, returns false if malformed
+func Unquote(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false
+	}
+	s = s[1 : len(s)-1]
+	if strings.IndexByte(s, '\\') < 0 {
+		if strings.IndexByte(s, '"') >= 0 {
+			return "", false
+		}
+		return s, true
+	}
+	var b = make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return "", false
+		case '\\':
+			if i++; i == len(s) {
+				return "", false
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b), true
+}
+
 func HttpHeaderEqual(a, b string) bool {
 	return strings.ToLower(a) == strings.ToLower(b)
 }
@@ -120,6 +120,45 @@ func TestSplit(t *testing.T) {
 	assert.ElementsMatch(t, []string{"ming", "hong", "hu"}, Split("\nming, hong, hu\n", ","))
 }
 
+func TestSplitQuoted(t *testing.T) {
+	var as = assert.New(t)
+	as.Equal([]string{"a", "b; c=\"x,y\"", "d"}, SplitQuoted(" a,, b; c=\"x,y\" ,d,", ','))
+	as.Equal([]string{`c="x\";y"`, "e"}, SplitQuoted(`c="x\";y"; e`, ';'))
+	as.Equal(0, len(SplitQuoted(" , ", ',')))
+}
+
+func TestIsToken(t *testing.T) {
+	var as = assert.New(t)
+	as.True(IsToken("permessage-deflate"))
+	as.True(IsToken("15"))
+	as.False(IsToken(""))
+	as.False(IsToken("a b"))
+	as.False(IsToken("a=b"))
+	as.False(IsToken(`"a"`))
+}
+
+func TestUnquote(t *testing.T) {
+	var as = assert.New(t)
+	var cases = []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{`"10"`, "10", true},
+		{`"a\"b"`, `a"b`, true},
+		{`""`, "", true},
+		{`"a"b"`, "", false},
+		{`"a\"`, "", false},
+		{`10`, "", false},
+		{`"`, "", false},
+	}
+	for _, item := range cases {
+		out, ok := Unquote(item.in)
+		as.Equal(item.ok, ok, item.in)
+		as.Equal(item.out, out, item.in)
+	}
+}
+
 func TestInCollection(t *testing.T) {
 	var as = assert.New(t)
 	as.Equal(true, InCollection("hong", []string{"lang", "hong"}))
@@ -0,0 +1,146 @@
+package gws
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// 接受连接失败时的退避时间
+	// Backoff delay when accepting connections fails
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// 限制请求头大小的读取器, 请求头读取完成后解除限制
+// Reader limiting the size of the request header, the limit is lifted after the header has been read
+type headerReader struct {
+	conn net.Conn
+
+	// 剩余可读取的字节数, 小于 0 表示不限制
+	// Remaining number of bytes that can be read, less than 0 means unlimited
+	remain int
+}
+
+func (c *headerReader) Read(p []byte) (int, error) {
+	if c.remain < 0 {
+		return c.conn.Read(p)
+	}
+	if c.remain == 0 {
+		return 0, ErrRequestHeaderTooLarge
+	}
+	if len(p) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.conn.Read(p)
+	c.remain -= n
+	return n, err
+}
+
+// 关闭所有监听器
+// Closes all listeners
+func closeListeners(listeners []net.Listener) {
+	for _, item := range listeners {
+		_ = item.Close()
+	}
+}
+
+// 是否为可以重试的错误
+// Whether it is a retryable error
+func isTemporary(err error) bool {
+	var e interface{ Temporary() bool }
+	return errors.As(err, &e) && e.Temporary()
+}
+
+// 计算下一次接受连接的退避时间
+// Calculates the backoff delay of the next accept
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	if delay *= 2; delay > maxAcceptDelay {
+		delay = maxAcceptDelay
+	}
+	return delay
+}
+
+// 处理新的连接: 读取 PROXY 协议头部, TLS 握手, 然后交给 net/http 或者读取升级请求
+// Serves a new connection: reads the PROXY protocol header, performs the TLS handshake,
+// then hands it over to net/http or reads the upgrade request
+func (c *Server) serveConn(conn net.Conn, config *tls.Config, handoff *handoffListener) {
+	conn = wrapPacketConn(conn)
+	if c.option.ProxyProtocol.Enabled {
+		pc, err := c.readProxyHeader(conn)
+		if err != nil {
+			c.OnError(conn, err)
+			_ = conn.Close()
+			return
+		}
+		conn = pc
+	}
+	if config != nil {
+		conn = tls.Server(conn, config)
+	}
+	if handoff != nil && c.handoff(conn, handoff) {
+		return
+	}
+	if br, r, err := c.readRequest(conn); err != nil {
+		c.OnError(conn, err)
+		c.rejectRequest(conn, err)
+	} else {
+		c.OnRequest(conn, br, r)
+	}
+}
+
+// 在 HandshakeReadTimeout 内读取请求, 请求头不能超过 MaxHeaderBytes
+// Reads the request within HandshakeReadTimeout, the request header must not exceed MaxHeaderBytes
+func (c *Server) readRequest(conn net.Conn) (*bufio.Reader, *http.Request, error) {
+	var reader = &headerReader{conn: conn, remain: c.option.MaxHeaderBytes}
+	var br = c.option.config.brPool.Get()
+	br.Reset(reader)
+
+	err := conn.SetReadDeadline(time.Now().Add(c.option.HandshakeReadTimeout))
+	var r *http.Request
+	if err == nil {
+		r, err = http.ReadRequest(br)
+	}
+	if err == nil {
+		r = r.WithContext(withProxyHeader(r.Context(), conn))
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			var state = tlsConn.ConnectionState()
+			r.TLS = &state
+		}
+		reader.remain = -1
+		err = conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		br.Reset(nil)
+		c.option.config.brPool.Put(br)
+		return nil, nil, err
+	}
+	return br, r, nil
+}
+
+// 拒绝读取失败的请求, 请求头过大时响应 431, 超时时响应 408
+// Rejects a request that failed to be read, 431 is answered if the header is too large, 408 on timeout
+func (c *Server) rejectRequest(conn net.Conn, err error) {
+	var status int
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrRequestHeaderTooLarge):
+		status = http.StatusRequestHeaderFieldsTooLarge
+	case errors.As(err, &netErr) && netErr.Timeout():
+		status, err = http.StatusRequestTimeout, ErrRequestTimeout
+	}
+	if status != 0 {
+		var e = &HandshakeError{StatusCode: status, Header: http.Header{}, Err: err}
+		e.Header.Set("Connection", "close")
+		_ = conn.SetWriteDeadline(time.Now().Add(c.option.HandshakeTimeout))
+		_ = c.upgrader.writeErr(conn, e)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,132 @@
+package gws
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 前几次接受连接返回临时错误的监听器
+// Listener returning temporary errors for the first accepts
+type flakyListener struct {
+	net.Listener
+	failures int64
+}
+
+func (c *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt64(&c.failures, -1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return c.Listener.Accept()
+}
+
+func TestRunListener(t *testing.T) {
+	var as = assert.New(t)
+
+	var run = func(option *ServerOption) (*Server, string) {
+		var server = NewServer(new(BuiltinEventHandler), option)
+		listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+		if !as.NoError(err) {
+			t.FailNow()
+		}
+		go server.RunListener(listener)
+		return server, listener.Addr().String()
+	}
+
+	t.Run("header too large", func(t *testing.T) {
+		_, addr := run(&ServerOption{MaxHeaderBytes: 1024})
+		conn, err := net.Dial("tcp", addr)
+		if !as.NoError(err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + addr + "\r\nX-Padding: " + strings.Repeat("a", 2048) + "\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if as.NoError(err) {
+			as.Equal(http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+			as.True(resp.Close)
+		}
+	})
+
+	t.Run("header within limit", func(t *testing.T) {
+		_, addr := run(&ServerOption{MaxHeaderBytes: 1024})
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:          "ws://" + addr,
+			RequestHeader: http.Header{"X-Padding": []string{strings.Repeat("a", 512)}},
+		})
+		if as.NoError(err) {
+			_ = client.NetConn().Close()
+		}
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		var server, addr = run(&ServerOption{HandshakeReadTimeout: 100 * time.Millisecond})
+		var errs = make(chan error, 1)
+		server.OnError = func(conn net.Conn, err error) { errs <- err }
+		conn, err := net.Dial("tcp", addr)
+		if !as.NoError(err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + addr + "\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if as.NoError(err) {
+			as.Equal(http.StatusRequestTimeout, resp.StatusCode)
+		}
+		var netErr net.Error
+		as.True(errors.As(<-errs, &netErr) && netErr.Timeout())
+	})
+
+	t.Run("accept backoff", func(t *testing.T) {
+		var logger = new(countingLogger)
+		var server = NewServer(new(BuiltinEventHandler), &ServerOption{Logger: logger})
+		server.OnError = func(conn net.Conn, err error) { as.Fail("unexpected error: " + err.Error()) }
+		listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+		if !as.NoError(err) {
+			return
+		}
+		var done = make(chan error, 1)
+		go func() { done <- server.RunListener(&flakyListener{Listener: listener, failures: 3}) }()
+		time.Sleep(100 * time.Millisecond)
+		as.Equal(int64(3), atomic.LoadInt64(&logger.count))
+
+		// 监听器关闭后返回 nil
+		// Returns nil after the listener is closed
+		_ = listener.Close()
+		select {
+		case err := <-done:
+			as.NoError(err)
+		case <-time.After(time.Second):
+			as.Fail("RunListener did not return")
+		}
+	})
+
+	t.Run("accept delay", func(t *testing.T) {
+		var delay time.Duration
+		for i := 0; i < 20; i++ {
+			delay = nextAcceptDelay(delay)
+		}
+		as.Equal(maxAcceptDelay, delay)
+		as.Equal(minAcceptDelay, nextAcceptDelay(0))
+		as.Equal(2*minAcceptDelay, nextAcceptDelay(minAcceptDelay))
+		as.False(isTemporary(errors.New("test")))
+	})
+}
+
+// 记录错误日志数量的日志工具
+// Logger counting the error logs
+type countingLogger struct {
+	count int64
+}
+
+func (c *countingLogger) Error(v ...any) {
+	atomic.AddInt64(&c.count, 1)
+}
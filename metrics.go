@@ -0,0 +1,67 @@
+package gws
+
+import "sync/atomic"
+
+type (
+	// Metrics 统计指标
+	// Metrics
+	Metrics struct {
+		// 因解压率超出限制而关闭的连接数
+		// Number of connections closed because the decompression ratio exceeded the limit
+		DecompressionRatioExceeded uint64
+
+		// 因解压速率超出限制而关闭的连接数
+		// Number of connections closed because the decompression rate exceeded the limit
+		DecompressionRateExceeded uint64
+
+		// 当前的连接数
+		// Number of current connections
+		ActiveConnections int64
+
+		// 因总连接数超出限制而拒绝的连接数
+		// Number of connections rejected because the total number of connections exceeded the limit
+		ConnectionLimitExceeded uint64
+
+		// 因单个 IP 的连接数超出限制而拒绝的连接数
+		// Number of connections rejected because the number of connections from a single IP exceeded the limit
+		ConnectionLimitPerIPExceeded uint64
+
+		// 因单个 IP 的握手速率超出限制而拒绝的连接数
+		// Number of connections rejected because the handshake rate of a single IP exceeded the limit
+		HandshakeRateExceeded uint64
+	}
+
+	// 统计指标计数器, 同一个配置下的连接共享
+	// Metrics counters, shared by connections under the same configuration
+	metrics struct {
+		decompressionRatioExceeded uint64
+		decompressionRateExceeded  uint64
+
+		activeConnections            int64
+		connectionLimitExceeded      uint64
+		connectionLimitPerIPExceeded uint64
+		handshakeRateExceeded        uint64
+	}
+)
+
+// 获取统计指标的快照
+// Gets a snapshot of the metrics
+func (c *metrics) snapshot() Metrics {
+	return Metrics{
+		DecompressionRatioExceeded: atomic.LoadUint64(&c.decompressionRatioExceeded),
+		DecompressionRateExceeded:  atomic.LoadUint64(&c.decompressionRateExceeded),
+
+		ActiveConnections:            atomic.LoadInt64(&c.activeConnections),
+		ConnectionLimitExceeded:      atomic.LoadUint64(&c.connectionLimitExceeded),
+		ConnectionLimitPerIPExceeded: atomic.LoadUint64(&c.connectionLimitPerIPExceeded),
+		HandshakeRateExceeded:        atomic.LoadUint64(&c.handshakeRateExceeded),
+	}
+}
+
+// Metrics 获取统计指标
+// Gets the metrics
+func (c *Upgrader) Metrics() Metrics { return c.option.config.metrics.snapshot() }
+
+// Metrics 获取统计指标
+// Gets the metrics
+func (c *Server) Metrics() Metrics { return c.upgrader.Metrics() }
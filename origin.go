@@ -0,0 +1,100 @@
+package gws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lxzan/gws/internal"
+)
+
+// 默认的来源检查, 没有 Origin 头的请求(非浏览器客户端)放行, 否则要求 Origin 的主机与请求的 Host 相同
+// Default origin check, requests without an Origin header (non-browser clients) are allowed,
+// otherwise the host of Origin must be the same as the Host of the request
+func checkSameOrigin(r *http.Request) bool {
+	var origin = r.Header.Get(internal.Origin.Key)
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// AllowOrigins 创建基于白名单的来源检查函数, 用于 ServerOption.CheckOrigin
+// 规则可以是 "https://example.com:8443", "example.com" 或者 "*.example.com".
+// 带协议的规则要求协议相同; 不带端口的规则匹配任意端口; "*." 开头的规则只匹配子域名; "*" 匹配任意来源.
+// 没有 Origin 头的请求(非浏览器客户端)放行.
+// Creates an allowlist-based origin check function for ServerOption.CheckOrigin
+// Patterns can be "https://example.com:8443", "example.com" or "*.example.com".
+// Patterns with a scheme require the same scheme; patterns without a port match any port;
+// patterns starting with "*." only match subdomains; "*" matches any origin.
+// Requests without an Origin header (non-browser clients) are allowed.
+func AllowOrigins(patterns ...string) func(r *http.Request) bool {
+	var rules = make([]originRule, 0, len(patterns))
+	for _, item := range patterns {
+		rules = append(rules, newOriginRule(item))
+	}
+	return func(r *http.Request) bool {
+		var origin = r.Header.Get(internal.Origin.Key)
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false
+		}
+		for _, rule := range rules {
+			if rule.match(u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// 来源白名单规则
+// Origin allowlist rule
+type originRule struct {
+	scheme   string
+	hostname string
+	port     string
+	wildcard bool
+}
+
+func newOriginRule(pattern string) originRule {
+	var rule originRule
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		rule.scheme, pattern = pattern[:i], pattern[i+3:]
+	}
+	if u, err := url.Parse("//" + pattern); err == nil {
+		rule.hostname, rule.port = u.Hostname(), u.Port()
+	} else {
+		rule.hostname = pattern
+	}
+	if strings.HasPrefix(rule.hostname, "*.") {
+		rule.wildcard, rule.hostname = true, rule.hostname[1:]
+	}
+	return rule
+}
+
+func (c originRule) match(u *url.URL) bool {
+	if c.scheme != "" && c.scheme != strings.ToLower(u.Scheme) {
+		return false
+	}
+	if c.port != "" && c.port != u.Port() {
+		return false
+	}
+	var hostname = strings.ToLower(u.Hostname())
+	switch {
+	case c.hostname == "*":
+		return true
+	case c.wildcard:
+		return strings.HasSuffix(hostname, c.hostname)
+	default:
+		return hostname == c.hostname
+	}
+}
@@ -0,0 +1,348 @@
+package gws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+)
+
+const (
+	// PROXY 协议 v1 头部的最大长度
+	// Maximum length of a PROXY protocol v1 header
+	proxyV1MaxLength = 107
+
+	// PROXY 协议 v2 的命令
+	// Commands of PROXY protocol v2
+	proxyCommandLocal = 0x0
+	proxyCommandProxy = 0x1
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+type (
+	// ProxyProtocol PROXY 协议(v1/v2)配置, 用于在负载均衡之后获取客户端的真实地址
+	// PROXY protocol (v1/v2) configuration, used to get the real address of the client behind a load balancer
+	ProxyProtocol struct {
+		// 是否解析 PROXY 协议头部, 头部在 TLS 握手和 HTTP 请求之前读取
+		// 开启后来自受信任来源的连接必须发送 PROXY 协议头部, 否则连接会被关闭.
+		// Whether to parse PROXY protocol headers, the header is read before the TLS handshake and the HTTP request
+		// When enabled, connections from trusted sources must send a PROXY protocol header, otherwise they are closed.
+		Enabled bool
+
+		// 受信任来源的 CIDR 列表, 也可以是单个 IP. 不受信任来源的连接不解析头部, 保持原有地址.
+		// 为空时不信任任何来源, 需要列出负载均衡的地址, 例如 10.0.0.0/8.
+		// CIDR list of trusted sources, single IPs are also accepted. Headers of connections from untrusted sources
+		// are not parsed, and their original addresses are kept.
+		// If it is empty, no source is trusted, the addresses of the load balancers must be listed, e.g. 10.0.0.0/8.
+		TrustedCIDRs []string
+
+		trusted []*net.IPNet
+	}
+
+	// ProxyHeader PROXY 协议头部
+	// PROXY protocol header
+	ProxyHeader struct {
+		// 协议版本, 1 或者 2
+		// Protocol version, 1 or 2
+		Version int
+
+		// 是否为 LOCAL 命令(例如负载均衡的健康检查), 此时连接保持原有地址
+		// Whether it is a LOCAL command (e.g. health checks of the load balancer), the connection keeps its original addresses
+		Local bool
+
+		// 客户端的真实地址, 未知时为 nil
+		// Real address of the client, nil if unknown
+		SourceAddr net.Addr
+
+		// 客户端连接的目标地址, 未知时为 nil
+		// Destination address the client connected to, nil if unknown
+		DestinationAddr net.Addr
+
+		// v2 头部携带的 TLV 数据
+		// TLV data carried by v2 headers
+		TLVs []ProxyTLV
+	}
+
+	// ProxyTLV PROXY 协议 v2 的 TLV 数据
+	// TLV data of PROXY protocol v2
+	ProxyTLV struct {
+		Type  byte
+		Value []byte
+	}
+
+	// 解析过 PROXY 协议头部的连接, 地址被替换为头部中的地址
+	// Connection whose PROXY protocol header has been parsed, the addresses are replaced by the ones in the header
+	proxyConn struct {
+		net.Conn
+		header *ProxyHeader
+
+		// 读取头部时多读取的数据
+		// Data read ahead while reading the header
+		buffered []byte
+	}
+
+	proxyHeaderKey struct{}
+)
+
+// TLV 获取指定类型的 TLV 数据
+// Gets the TLV data of the specified type
+func (c *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, item := range c.TLVs {
+		if item.Type == typ {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyHeaderFromRequest 获取请求所在连接的 PROXY 协议头部, 可以在 Authorize 中使用
+// Gets the PROXY protocol header of the connection the request came from, can be used in Authorize
+func ProxyHeaderFromRequest(r *http.Request) (*ProxyHeader, bool) {
+	header, ok := r.Context().Value(proxyHeaderKey{}).(*ProxyHeader)
+	return header, ok
+}
+
+// 将 PROXY 协议头部附加到上下文
+// Attaches the PROXY protocol header to the context
+func withProxyHeader(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyHeaderKey{}, pc.header)
+	}
+	return ctx
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if len(c.buffered) > 0 {
+		var n = copy(p, c.buffered)
+		c.buffered = c.buffered[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header.Local || c.header.SourceAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.header.SourceAddr
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header.Local || c.header.DestinationAddr == nil {
+		return c.Conn.LocalAddr()
+	}
+	return c.header.DestinationAddr
+}
+
+// 解析 CIDR 列表, 单个 IP 按 /32 或者 /128 处理, 忽略非法的条目
+// Parses the CIDR list, single IPs are treated as /32 or /128, invalid entries are ignored
+func parseCIDRs(list []string, logger Logger) []*net.IPNet {
+	var results []*net.IPNet
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			item += internal.SelectValue(strings.Contains(item, ":"), "/128", "/32")
+		}
+		if _, ipNet, err := net.ParseCIDR(item); err == nil {
+			results = append(results, ipNet)
+		} else {
+			logger.Error("gws: invalid cidr " + strconv.Quote(item))
+		}
+	}
+	return results
+}
+
+// IP 是否属于 CIDR 列表
+// Whether the IP belongs to the CIDR list
+func containsIP(list []*net.IPNet, s string) bool {
+	var ip = net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, item := range list {
+		if item.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取受信任来源的 PROXY 协议头部, 不受信任来源的连接原样返回
+// Reads the PROXY protocol header of a trusted source, connections from untrusted sources are returned as is
+func (c *Server) readProxyHeader(conn net.Conn) (net.Conn, error) {
+	var trusted = c.option.ProxyProtocol.trusted
+	if !containsIP(trusted, remoteHost(conn.RemoteAddr())) {
+		return conn, nil
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(c.option.HandshakeReadTimeout)); err != nil {
+		return nil, err
+	}
+	var br = bufio.NewReaderSize(conn, 256)
+	header, err := parseProxyHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	var pc = &proxyConn{Conn: conn, header: header}
+	if n := br.Buffered(); n > 0 {
+		pc.buffered, _ = br.Peek(n)
+	}
+	return pc, nil
+}
+
+// 解析 PROXY 协议头部
+// Parses the PROXY protocol header
+func parseProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	signature, err := br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(signature, proxyV1Signature):
+		return parseProxyHeaderV1(br)
+	case bytes.Equal(signature, proxyV2Signature):
+		return parseProxyHeaderV2(br)
+	default:
+		return nil, ErrProxyProtocol
+	}
+}
+
+// 解析 v1 文本格式的头部, 例如 "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+// Parses a v1 header in text format, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func parseProxyHeaderV1(br *bufio.Reader) (*ProxyHeader, error) {
+	var line = make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == proxyV1MaxLength {
+			return nil, ErrProxyProtocol
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyProtocol
+	}
+
+	var fields = strings.Split(string(line[:len(line)-2]), " ")
+	var header = &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyProtocol
+	}
+	src, err1 := parseProxyAddrV1(fields[1], fields[2], fields[4])
+	dst, err2 := parseProxyAddrV1(fields[1], fields[3], fields[5])
+	if err1 != nil || err2 != nil {
+		return nil, ErrProxyProtocol
+	}
+	header.SourceAddr, header.DestinationAddr = src, dst
+	return header, nil
+}
+
+func parseProxyAddrV1(family, host, port string) (*net.TCPAddr, error) {
+	var ip = net.ParseIP(host)
+	if ip == nil || (family == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrProxyProtocol
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrProxyProtocol
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// 解析 v2 二进制格式的头部
+// Parses a v2 header in binary format
+func parseProxyHeaderV2(br *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, ErrProxyProtocol
+	}
+	var payload = make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+
+	var header = &ProxyHeader{Version: 2}
+	switch fixed[12] & 0x0F {
+	case proxyCommandLocal:
+		header.Local = true
+	case proxyCommandProxy:
+	default:
+		return nil, ErrProxyProtocol
+	}
+
+	// 地址族和传输协议, 未知的地址族按 UNSPEC 处理, 跳过地址部分
+	// Address family and transport protocol, unknown families are treated as UNSPEC and the addresses are skipped
+	var n int
+	switch fixed[13] >> 4 {
+	case 0x1:
+		if n = 12; len(payload) < n {
+			return nil, ErrProxyProtocol
+		}
+		header.SourceAddr, header.DestinationAddr = proxyAddrV2(fixed[13], payload[0:4], payload[4:8], payload[8:12])
+	case 0x2:
+		if n = 36; len(payload) < n {
+			return nil, ErrProxyProtocol
+		}
+		header.SourceAddr, header.DestinationAddr = proxyAddrV2(fixed[13], payload[0:16], payload[16:32], payload[32:36])
+	case 0x3:
+		if n = 216; len(payload) < n {
+			return nil, ErrProxyProtocol
+		}
+		header.SourceAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[0:108], "\x00")), Net: "unix"}
+		header.DestinationAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: "unix"}
+	default:
+		n = len(payload)
+	}
+
+	for rest := payload[n:]; len(rest) > 0; {
+		if len(rest) < 3 {
+			return nil, ErrProxyProtocol
+		}
+		var length = int(binary.BigEndian.Uint16(rest[1:3]))
+		if len(rest) < 3+length {
+			return nil, ErrProxyProtocol
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: rest[0], Value: rest[3 : 3+length]})
+		rest = rest[3+length:]
+	}
+	return header, nil
+}
+
+func proxyAddrV2(family byte, src, dst, ports []byte) (net.Addr, net.Addr) {
+	var srcPort = int(binary.BigEndian.Uint16(ports[0:2]))
+	var dstPort = int(binary.BigEndian.Uint16(ports[2:4]))
+	if family&0x0F == 0x2 {
+		return &net.UDPAddr{IP: net.IP(src), Port: srcPort}, &net.UDPAddr{IP: net.IP(dst), Port: dstPort}
+	}
+	return &net.TCPAddr{IP: net.IP(src), Port: srcPort}, &net.TCPAddr{IP: net.IP(dst), Port: dstPort}
+}
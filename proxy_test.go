@@ -0,0 +1,236 @@
+package gws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 构造 v2 头部
+// Builds a v2 header
+func newProxyHeaderV2(command, family byte, addresses []byte, tlvs ...ProxyTLV) []byte {
+	var payload = append([]byte{}, addresses...)
+	for _, item := range tlvs {
+		payload = append(payload, item.Type, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(item.Value)))
+		payload = append(payload, item.Value...)
+	}
+	var buf = append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(buf[14:16], uint16(len(payload)))
+	return append(buf, payload...)
+}
+
+func TestProxyHeader(t *testing.T) {
+	var as = assert.New(t)
+
+	var parse = func(s string) (*ProxyHeader, error) {
+		return parseProxyHeader(bufio.NewReader(strings.NewReader(s)))
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		header, err := parse("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n")
+		if as.NoError(err) {
+			as.Equal(1, header.Version)
+			as.Equal("192.168.0.1:56324", header.SourceAddr.String())
+			as.Equal("192.168.0.11:443", header.DestinationAddr.String())
+		}
+
+		header, err = parse("PROXY TCP6 ::1 ::2 1 2\r\n")
+		if as.NoError(err) {
+			as.Equal("[::1]:1", header.SourceAddr.String())
+		}
+
+		header, err = parse("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+		if as.NoError(err) {
+			as.True(header.Local)
+			as.Nil(header.SourceAddr)
+		}
+
+		for _, s := range []string{
+			"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+			"PROXY TCP4 ::1 192.168.0.11 56324 443\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 056 443\r\n",
+			"PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n",
+			"PROXY " + strings.Repeat("a", proxyV1MaxLength) + "\r\n",
+			"GET / HTTP/1.1\r\nHost: localhost\r\n",
+		} {
+			_, err = parse(s)
+			as.ErrorIs(err, ErrProxyProtocol)
+		}
+		_, err = parse("PROXY TCP4")
+		as.Error(err)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		var addresses = []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x01, 0xBB}
+		var data = newProxyHeaderV2(proxyCommandProxy, 0x11, addresses, ProxyTLV{Type: 0x01, Value: []byte("h2")}, ProxyTLV{Type: 0xEA, Value: []byte("vpce-1")})
+		var br = bufio.NewReader(bytes.NewReader(append(data, "GET"...)))
+		header, err := parseProxyHeader(br)
+		if as.NoError(err) {
+			as.Equal(2, header.Version)
+			as.False(header.Local)
+			as.Equal("10.0.0.1:8080", header.SourceAddr.String())
+			as.Equal("10.0.0.2:443", header.DestinationAddr.String())
+			as.Equal(2, len(header.TLVs))
+			value, ok := header.TLV(0xEA)
+			as.True(ok)
+			as.Equal("vpce-1", string(value))
+			_, ok = header.TLV(0x02)
+			as.False(ok)
+			rest, _ := br.Peek(3)
+			as.Equal("GET", string(rest))
+		}
+
+		header, err = parseProxyHeader(bufio.NewReader(bytes.NewReader(newProxyHeaderV2(proxyCommandProxy, 0x22, make([]byte, 36)))))
+		if as.NoError(err) {
+			_, ok := header.SourceAddr.(*net.UDPAddr)
+			as.True(ok)
+		}
+
+		var unix = make([]byte, 216)
+		copy(unix, "/tmp/src.sock")
+		header, err = parseProxyHeader(bufio.NewReader(bytes.NewReader(newProxyHeaderV2(proxyCommandProxy, 0x31, unix))))
+		if as.NoError(err) {
+			as.Equal("/tmp/src.sock", header.SourceAddr.String())
+		}
+
+		header, err = parseProxyHeader(bufio.NewReader(bytes.NewReader(newProxyHeaderV2(proxyCommandLocal, 0x00, nil))))
+		if as.NoError(err) {
+			as.True(header.Local)
+		}
+
+		for _, data := range [][]byte{
+			newProxyHeaderV2(0x2, 0x11, addresses),
+			newProxyHeaderV2(proxyCommandProxy, 0x11, addresses[:8]),
+			newProxyHeaderV2(proxyCommandProxy, 0x11, append(addresses, 0x01)),
+			newProxyHeaderV2(proxyCommandProxy, 0x11, append(addresses, 0x01, 0x00, 0x05, 'a')),
+		} {
+			_, err = parseProxyHeader(bufio.NewReader(bytes.NewReader(data)))
+			as.Error(err)
+		}
+
+		var invalidVersion = newProxyHeaderV2(proxyCommandProxy, 0x11, addresses)
+		invalidVersion[12] = 0x11
+		_, err = parseProxyHeader(bufio.NewReader(bytes.NewReader(invalidVersion)))
+		as.ErrorIs(err, ErrProxyProtocol)
+	})
+
+	t.Run("cidr", func(t *testing.T) {
+		var list = parseCIDRs([]string{"10.0.0.0/8", "127.0.0.1", "::1", "bad"}, defaultLogger)
+		as.Equal(3, len(list))
+		as.True(containsIP(list, "10.1.2.3"))
+		as.True(containsIP(list, "127.0.0.1"))
+		as.True(containsIP(list, "::1"))
+		as.False(containsIP(list, "127.0.0.2"))
+		as.False(containsIP(list, "pipe"))
+	})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	var as = assert.New(t)
+
+	var run = func(option *ServerOption) (string, chan *Conn) {
+		var sockets = make(chan *Conn, 1)
+		var handler = new(webSocketMocker)
+		handler.onOpen = func(socket *Conn) { sockets <- socket }
+		var server = NewServer(handler, option)
+		listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+		if !as.NoError(err) {
+			t.FailNow()
+		}
+		go server.RunListener(listener)
+		return listener.Addr().String(), sockets
+	}
+
+	t.Run("v2 with tlv", func(t *testing.T) {
+		var tlvs = make(chan []byte, 1)
+		addr, sockets := run(&ServerOption{
+			ProxyProtocol: ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"127.0.0.0/8"}},
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				header, ok := ProxyHeaderFromRequest(r)
+				if !ok {
+					return ErrUnauthorized
+				}
+				value, _ := header.TLV(0xE0)
+				tlvs <- value
+				return nil
+			},
+		})
+		conn, err := net.Dial("tcp", addr)
+		if !as.NoError(err) {
+			return
+		}
+		var addresses = []byte{203, 0, 113, 7, 10, 0, 0, 2, 0x30, 0x39, 0x01, 0xBB}
+		_, _ = conn.Write(newProxyHeaderV2(proxyCommandProxy, 0x11, addresses, ProxyTLV{Type: 0xE0, Value: []byte("tenant")}))
+		client, _, err := NewClientFromConn(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr}, conn)
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("tenant", string(<-tlvs))
+		var socket = <-sockets
+		as.Equal("203.0.113.7:12345", socket.RemoteAddr().String())
+		as.Equal("10.0.0.2:443", socket.LocalAddr().String())
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		addr, sockets := run(&ServerOption{ProxyProtocol: ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"127.0.0.1"}}})
+		conn, err := net.Dial("tcp", addr)
+		if !as.NoError(err) {
+			return
+		}
+		_, _ = conn.Write([]byte("PROXY TCP4 198.51.100.1 10.0.0.2 5000 443\r\n"))
+		client, _, err := NewClientFromConn(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr}, conn)
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("198.51.100.1:5000", (<-sockets).RemoteAddr().String())
+	})
+
+	t.Run("untrusted source", func(t *testing.T) {
+		addr, sockets := run(&ServerOption{ProxyProtocol: ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"10.0.0.0/8"}}})
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("127.0.0.1", remoteHost((<-sockets).RemoteAddr()))
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		addr, _ := run(&ServerOption{ProxyProtocol: ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"127.0.0.0/8"}}})
+		_, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+		as.Error(err)
+	})
+
+	t.Run("no trusted cidrs", func(t *testing.T) {
+		// 没有配置受信任来源时不解析任何头部
+		// No header is parsed if no trusted source is configured
+		addr, sockets := run(&ServerOption{ProxyProtocol: ProxyProtocol{Enabled: true}})
+		conn, err := net.Dial("tcp", addr)
+		if !as.NoError(err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 198.51.100.1 10.0.0.2 5000 443\r\n"))
+		_, _, err = NewClientFromConn(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr}, conn)
+		as.Error(err)
+
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("127.0.0.1", remoteHost((<-sockets).RemoteAddr()))
+	})
+}
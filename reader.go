@@ -3,6 +3,7 @@ package gws
 import (
 	"bytes"
 	"fmt"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/lxzan/gws/internal"
@@ -62,6 +63,22 @@ func (c *Conn) readControl() error {
 	}
 }
 
+// 将解码错误转换为关闭连接的错误, 超出解压限制时以 1009 关闭并记录到统计指标
+// Converts a decoding error into an error that closes the connection,
+// closes with 1009 and records it in the metrics when the decompression limit is exceeded
+func (c *Conn) decodeError(err error) error {
+	switch err {
+	case ErrDecompressionRatio:
+		atomic.AddUint64(&c.config.metrics.decompressionRatioExceeded, 1)
+	case ErrDecompressionRate:
+		atomic.AddUint64(&c.config.metrics.decompressionRateExceeded, 1)
+	case internal.CloseMessageTooLarge:
+	default:
+		return internal.NewError(internal.CloseInternalErr, err)
+	}
+	return internal.NewError(internal.CloseMessageTooLarge, err)
+}
+
 // 读取消息
 // Reads a message
 func (c *Conn) readMessage() error {
@@ -82,7 +99,8 @@ func (c *Conn) readMessage() error {
 	// MUST be 0 unless an extension is negotiated that defines meanings for non-zero values.
 	// If a nonzero value is received and none of the negotiated extensions defines the meaning of such a nonzero value,
 	// the receiving endpoint MUST _Fail the WebSocket Connection_.
-	if !c.pd.Enabled && (c.fh.GetRSV1() || c.fh.GetRSV2() || c.fh.GetRSV3()) {
+	var rsv = c.fh.GetRSV()
+	if rsv&^c.rsvMask != 0 {
 		return internal.CloseProtocolError
 	}
 
@@ -92,7 +110,6 @@ func (c *Conn) readMessage() error {
 	}
 
 	var opcode = c.fh.GetOpcode()
-	var compressed = c.pd.Enabled && c.fh.GetRSV1()
 	if !opcode.isDataFrame() {
 		return c.readControl()
 	}
@@ -116,17 +133,15 @@ func (c *Conn) readMessage() error {
 
 	if fin && opcode != OpcodeContinuation {
 		*(*[]byte)(unsafe.Pointer(buf)) = p
-		if !compressed {
-			closer.Data = nil
-		}
-		return c.emitMessage(&Message{Opcode: opcode, Data: buf, compressed: compressed})
+		closer.Data = nil
+		return c.emitMessage(&Message{Opcode: opcode, Data: buf, rsv: rsv})
 	}
 
 	// 处理分片消息
 	// processing segmented messages
 	if !fin && opcode != OpcodeContinuation {
 		c.continuationFrame.initialized = true
-		c.continuationFrame.compressed = compressed
+		c.continuationFrame.rsv = rsv
 		c.continuationFrame.opcode = opcode
 		c.continuationFrame.buffer = bytes.NewBuffer(make([]byte, 0, contentLength))
 	}
@@ -142,7 +157,7 @@ func (c *Conn) readMessage() error {
 		return nil
 	}
 
-	msg := &Message{Opcode: c.continuationFrame.opcode, Data: c.continuationFrame.buffer, compressed: c.continuationFrame.compressed}
+	msg := &Message{Opcode: c.continuationFrame.opcode, Data: c.continuationFrame.buffer, rsv: c.continuationFrame.rsv}
 	c.continuationFrame.reset()
 	return c.emitMessage(msg)
 }
@@ -176,12 +191,11 @@ func (c *Conn) dispatchControl(opcode Opcode, payload []byte, err error) error {
 // 发射消息事件
 // Emit onmessage event
 func (c *Conn) emitMessage(msg *Message) (err error) {
-	if msg.compressed {
-		msg.Data, err = c.deflater.Decompress(msg.Data, c.dpsWindow.dict)
-		if err != nil {
-			return internal.NewError(internal.CloseInternalErr, err)
+	if msg.rsv != 0 {
+		if err = c.decodeMessage(msg); err != nil {
+			_ = msg.Close()
+			return c.decodeError(err)
 		}
-		_, _ = c.dpsWindow.Write(msg.Bytes())
 	}
 	if !internal.CheckEncoding(c.config.CheckUtf8Enabled, uint8(msg.Opcode), msg.Bytes()) {
 		return internal.NewError(internal.CloseUnsupportedData, ErrTextEncoding)
@@ -0,0 +1,277 @@
+package gws
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+)
+
+type (
+	// ReconnectingClient 自动重连的客户端
+	// 连接断开后按指数退避加随机抖动的间隔重新拨号, 断线期间写入的消息会被缓存, 重连成功后按顺序发送.
+	// Auto-reconnecting client
+	// After the connection is lost, it dials again at intervals of exponential backoff with random jitter.
+	// Messages written while disconnected are buffered and sent in order after reconnecting.
+	ReconnectingClient struct {
+		handler   Event
+		option    *ClientOption
+		reconnect *ReconnectOption
+
+		mu     sync.Mutex
+		conn   *Conn
+		buffer []bufferedMessage
+		closed bool
+		err    error
+		ctx    context.Context
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+
+	// 断线期间缓存的消息
+	// Message buffered while disconnected
+	bufferedMessage struct {
+		opcode  Opcode
+		payload []byte
+	}
+
+	// 在 OnOpen 之后发送缓存的消息并切换到新的连接
+	// Sends the buffered messages and switches to the new connection after OnOpen
+	reconnectHandler struct {
+		Event
+		client *ReconnectingClient
+	}
+)
+
+func (c *reconnectHandler) OnOpen(socket *Conn) {
+	c.Event.OnOpen(socket)
+	c.client.attach(socket)
+}
+
+// NewReconnectingClient 创建自动重连的客户端, 在后台拨号并保持连接, 每次连接成功都会触发 OnOpen
+// 握手响应为 ReconnectOption.FatalStatusCodes 中的状态码, 或者地址非法时停止重连, 可以通过 Done 和 Err 获取结果.
+// Creates an auto-reconnecting client, which dials and keeps the connection in the background, OnOpen is triggered on every connection.
+// Reconnecting stops if the handshake response has a status code in ReconnectOption.FatalStatusCodes or the address is invalid,
+// the result can be obtained through Done and Err.
+func NewReconnectingClient(handler Event, option *ClientOption, reconnect *ReconnectOption) *ReconnectingClient {
+	c := &ReconnectingClient{
+		handler:   handler,
+		option:    initClientOption(option),
+		reconnect: initReconnectOption(reconnect),
+		done:      make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run()
+	return c
+}
+
+// Conn 返回当前的连接, 断线期间返回 nil
+// Returns the current connection, nil while disconnected
+func (c *ReconnectingClient) Conn() *Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// Done 停止重连后关闭的通道
+// Channel closed after reconnecting stops
+func (c *ReconnectingClient) Done() <-chan struct{} { return c.done }
+
+// Err 导致停止重连的错误, 调用 Close 停止时为 nil
+// The error that stopped reconnecting, nil if it was stopped by Close
+func (c *ReconnectingClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// WriteString 写入文本消息, 断线期间缓存消息
+// Writes a text message, the message is buffered while disconnected
+func (c *ReconnectingClient) WriteString(s string) error {
+	return c.WriteMessage(OpcodeText, internal.StringToBytes(s))
+}
+
+// WriteMessage 写入文本/二进制消息, 断线期间缓存消息, 缓存已满时返回 ErrReconnectBufferFull
+// Writes a text/binary message, the message is buffered while disconnected, returns ErrReconnectBufferFull if the buffer is full
+func (c *ReconnectingClient) WriteMessage(opcode Opcode, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.closed {
+			return internal.SelectValue(c.err != nil, c.err, ErrConnClosed)
+		}
+		var conn = c.conn
+		if conn == nil {
+			break
+		}
+
+		// 写入时不持有锁, 慢速的连接不会阻塞其他调用者和重连
+		// The lock is not held while writing, so a slow connection does not block other callers and reconnection
+		c.mu.Unlock()
+		var err = conn.WriteMessage(opcode, payload)
+		c.mu.Lock()
+
+		// 连接正在关闭时写入失败的消息会被缓存; 期间已经建立了新的连接时写入新的连接
+		// A message that failed to be written while the connection is closing is buffered;
+		// if a new connection has been established in the meantime, it is written to the new connection
+		if err == nil || !(errors.Is(err, ErrConnClosed) || conn.isClosed()) {
+			return err
+		}
+		if c.conn == conn {
+			break
+		}
+	}
+	if len(c.buffer) >= c.reconnect.BufferSize {
+		return ErrReconnectBufferFull
+	}
+	c.buffer = append(c.buffer, bufferedMessage{opcode: opcode, payload: append([]byte(nil), payload...)})
+	return nil
+}
+
+// Close 停止重连并关闭当前的连接, 正在进行的拨号会被中断, 缓存的消息会被丢弃
+// Stops reconnecting and closes the current connection, the dial in progress is interrupted, the buffered messages are discarded
+func (c *ReconnectingClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	var conn = c.conn
+	c.closed, c.conn, c.buffer = true, nil, nil
+	c.cancel()
+	c.mu.Unlock()
+
+	if conn != nil {
+		return conn.WriteClose(internal.CloseNormalClosure.Uint16(), nil)
+	}
+	return nil
+}
+
+// 拨号并保持连接, 直到调用 Close 或者遇到无法恢复的错误
+// Dials and keeps the connection until Close is called or an unrecoverable error occurs
+func (c *ReconnectingClient) run() {
+	defer close(c.done)
+
+	// failures 是连续拨号失败的次数, retries 是决定退避的次数, 只有稳定的连接才会重置
+	// failures is the number of consecutive dial failures, retries determines the backoff and is only reset by stable connections
+	var failures, retries = 0, 0
+	var handler = &reconnectHandler{Event: c.handler, client: c}
+	for connected := false; ; {
+		socket, resp, err := NewClientContext(c.ctx, handler, c.option)
+		if err != nil {
+			failures++
+			retries++
+			if c.isFatal(resp, err) || (c.reconnect.MaxAttempts > 0 && failures >= c.reconnect.MaxAttempts) {
+				c.stop(err)
+				return
+			}
+			if !c.wait(c.backoff(retries)) {
+				return
+			}
+			continue
+		}
+
+		failures = 0
+		if connected {
+			c.reconnect.OnReconnect(socket)
+		}
+		connected = true
+		var openedAt = time.Now()
+		socket.ReadLoop()
+		if !c.detach() {
+			return
+		}
+		if time.Since(openedAt) >= c.reconnect.StableDuration {
+			retries = 0
+		}
+		retries++
+		if !c.wait(c.backoff(retries)) {
+			return
+		}
+	}
+}
+
+// 是否为无法通过重连恢复的错误
+// Whether the error cannot be recovered by reconnecting
+func (c *ReconnectingClient) isFatal(resp *http.Response, err error) bool {
+	if resp != nil {
+		for _, code := range c.reconnect.FatalStatusCodes {
+			if resp.StatusCode == code {
+				return true
+			}
+		}
+	}
+	var urlErr *url.Error
+	return errors.Is(err, ErrUnsupportedProtocol) || (errors.As(err, &urlErr) && urlErr.Op == "parse")
+}
+
+// 第 n 次失败后的等待时间: min(InitialBackoff*Multiplier^(n-1), MaxBackoff), 再减去随机抖动
+// Wait time after the n-th failure: min(InitialBackoff*Multiplier^(n-1), MaxBackoff), minus the random jitter
+func (c *ReconnectingClient) backoff(n int) time.Duration {
+	var d = float64(c.reconnect.InitialBackoff) * math.Pow(c.reconnect.Multiplier, float64(n-1))
+	d = math.Min(d, float64(c.reconnect.MaxBackoff))
+	return time.Duration(d * (1 - c.reconnect.Jitter*rand.Float64()))
+}
+
+// 等待一段时间, 期间调用 Close 时返回 false
+// Waits for a while, returns false if Close is called in the meantime
+func (c *ReconnectingClient) wait(d time.Duration) bool {
+	var timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// 发送缓存的消息并切换到新的连接, 已经调用 Close 时关闭连接
+// Sends the buffered messages and switches to the new connection, closes the connection if Close has been called
+func (c *ReconnectingClient) attach(socket *Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		_ = socket.WriteClose(internal.CloseNormalClosure.Uint16(), nil)
+		return
+	}
+	var sent = 0
+	for _, item := range c.buffer {
+		// 连接已经断开时, 剩余的消息留到下一次连接
+		// If the connection is already lost, the remaining messages are left for the next connection
+		if err := socket.WriteMessage(item.opcode, item.payload); err != nil {
+			break
+		}
+		sent++
+	}
+	c.buffer = append(c.buffer[:0], c.buffer[sent:]...)
+	c.conn = socket
+}
+
+// 连接断开, 已经调用 Close 时返回 false
+// The connection is lost, returns false if Close has been called
+func (c *ReconnectingClient) detach() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	return !c.closed
+}
+
+// 遇到无法恢复的错误, 停止重连
+// Stops reconnecting after an unrecoverable error
+func (c *ReconnectingClient) stop(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed, c.err, c.buffer = true, err, nil
+		c.cancel()
+	}
+}
@@ -0,0 +1,192 @@
+package gws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectingClient(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("reconnect", func(t *testing.T) {
+		var handshakes int32
+		var gate = make(chan struct{})
+		var sockets = make(chan *Conn, 2)
+		var messages = make(chan string, 8)
+		var handler = new(webSocketMocker)
+		handler.onOpen = func(socket *Conn) { sockets <- socket }
+		handler.onMessage = func(socket *Conn, message *Message) { messages <- message.Data.String() }
+		var server = NewServer(handler, &ServerOption{
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				// 阻塞第二次握手, 保持客户端处于断线状态
+				// Blocks the second handshake to keep the client disconnected
+				if atomic.AddInt32(&handshakes, 1) == 2 {
+					<-gate
+				}
+				return nil
+			},
+		})
+		var addr = "127.0.0.1:" + nextPort()
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		// 顺序: OnReconnect, OnOpen, 缓存的消息
+		// Order: OnReconnect, OnOpen, the buffered messages
+		var opened = make(chan struct{}, 2)
+		var client = NewReconnectingClient(
+			&webSocketMocker{onOpen: func(socket *Conn) {
+				_ = socket.WriteString("open")
+				opened <- struct{}{}
+			}},
+			&ClientOption{Addr: "ws://" + addr},
+			&ReconnectOption{
+				InitialBackoff: 10 * time.Millisecond,
+				BufferSize:     2,
+				OnReconnect:    func(socket *Conn) { _ = socket.WriteString("subscribe") },
+			},
+		)
+		defer client.Close()
+
+		<-opened
+		as.Equal("open", <-messages)
+		_ = (<-sockets).NetConn().Close()
+		for client.Conn() != nil {
+			time.Sleep(5 * time.Millisecond)
+		}
+		as.NoError(client.WriteString("a"))
+		as.NoError(client.WriteString("b"))
+		as.ErrorIs(client.WriteString("c"), ErrReconnectBufferFull)
+		close(gate)
+
+		<-sockets
+		<-opened
+		for _, expected := range []string{"subscribe", "open", "a", "b"} {
+			select {
+			case msg := <-messages:
+				as.Equal(expected, msg)
+			case <-time.After(time.Second):
+				as.Fail("timeout waiting for " + expected)
+			}
+		}
+		as.NoError(client.WriteString("d"))
+		as.Equal("d", <-messages)
+
+		as.NoError(client.Close())
+		<-client.Done()
+		as.NoError(client.Err())
+		as.ErrorIs(client.WriteString("e"), ErrConnClosed)
+		as.NoError(client.Close())
+	})
+
+	t.Run("slow connection", func(t *testing.T) {
+		// 服务端不读取数据, 客户端的写入会阻塞
+		// The server does not read, so writes of the client block
+		var _, socket = newPeer(new(webSocketMocker), &ServerOption{}, new(webSocketMocker), &ClientOption{})
+		var client = &ReconnectingClient{reconnect: initReconnectOption(&ReconnectOption{BufferSize: 1}), conn: socket}
+		var result = make(chan error, 1)
+		go func() { result <- client.WriteString("a") }()
+		time.Sleep(50 * time.Millisecond)
+
+		// 阻塞的写入不会持有锁
+		// A blocked write does not hold the lock
+		var locked = make(chan struct{})
+		go func() { _ = client.Conn(); close(locked) }()
+		select {
+		case <-locked:
+		case <-time.After(time.Second):
+			as.Fail("the lock is held while writing")
+		}
+
+		// 连接关闭导致写入失败时缓存消息
+		// The message is buffered if the write fails because the connection is closed
+		_ = socket.NetConn().Close()
+		as.NoError(<-result)
+		as.Equal(1, len(client.buffer))
+	})
+
+	t.Run("fatal status", func(t *testing.T) {
+		var server = NewServer(new(BuiltinEventHandler), &ServerOption{
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				return NewHandshakeError(http.StatusForbidden, "forbidden")
+			},
+		})
+		var addr = "127.0.0.1:" + nextPort()
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var client = NewReconnectingClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr}, nil)
+		select {
+		case <-client.Done():
+			as.Error(client.Err())
+			as.Equal(client.Err(), client.WriteString("hello"))
+		case <-time.After(time.Second):
+			as.Fail("reconnecting did not stop")
+		}
+	})
+
+	t.Run("short sessions", func(t *testing.T) {
+		// 握手成功后立即断开的服务器, 退避继续增长
+		// The backoff keeps growing against a server that closes right after the handshake
+		var handshakes int64
+		var handler = new(webSocketMocker)
+		handler.onOpen = func(socket *Conn) {
+			atomic.AddInt64(&handshakes, 1)
+			_ = socket.NetConn().Close()
+		}
+		var addr = "127.0.0.1:" + nextPort()
+		go NewServer(handler, nil).Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var client = NewReconnectingClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr}, &ReconnectOption{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     time.Second,
+		})
+		time.Sleep(300 * time.Millisecond)
+		_ = client.Close()
+		as.LessOrEqual(atomic.LoadInt64(&handshakes), int64(8))
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		var dials int64
+		var client = NewReconnectingClient(
+			new(BuiltinEventHandler),
+			&ClientOption{
+				Addr: "ws://127.0.0.1:" + nextPort(),
+				NewDialer: func() (Dialer, error) {
+					atomic.AddInt64(&dials, 1)
+					return &net.Dialer{}, nil
+				},
+			},
+			&ReconnectOption{InitialBackoff: time.Millisecond, MaxAttempts: 3},
+		)
+		<-client.Done()
+		as.Error(client.Err())
+		as.Equal(int64(3), atomic.LoadInt64(&dials))
+
+		client = NewReconnectingClient(new(BuiltinEventHandler), &ClientOption{Addr: "http://127.0.0.1"}, nil)
+		<-client.Done()
+		as.True(errors.Is(client.Err(), ErrUnsupportedProtocol))
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		var client = &ReconnectingClient{reconnect: initReconnectOption(&ReconnectOption{
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     time.Second,
+			Jitter:         0.5,
+		})}
+		for i := 0; i < 16; i++ {
+			var d = client.backoff(1)
+			as.True(d >= 50*time.Millisecond && d <= 100*time.Millisecond)
+			d = client.backoff(3)
+			as.True(d >= 200*time.Millisecond && d <= 400*time.Millisecond)
+			d = client.backoff(64)
+			as.True(d >= 500*time.Millisecond && d <= time.Second)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package gws
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// 重定向到其他主机时不再发送的请求头
+// Request headers no longer sent after being redirected to another host
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Cookie2":             true,
+	"Proxy-Authorization": true,
+}
+
+// 是否应该跟随握手响应的重定向. ws+unix 连接不支持重定向.
+// Whether the redirect of the handshake response should be followed. ws+unix connections do not support redirects.
+func (c *connector) shouldRedirect(URL *url.URL, resp *http.Response) bool {
+	if resp == nil || c.option.MaxRedirects <= 0 || URL.Scheme == schemeUnix {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// 解析重定向的地址, http 和 https 分别转换为 ws 和 wss, 不允许从 wss 降级到 ws
+// Parses the redirect location, http and https are converted to ws and wss respectively,
+// downgrading from wss to ws is not allowed
+func (c *connector) redirect(URL *url.URL, resp *http.Response) (*url.URL, error) {
+	var location = resp.Header.Get("Location")
+	if location == "" {
+		return nil, ErrRedirect
+	}
+	next, err := URL.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch next.Scheme {
+	case "http":
+		next.Scheme = "ws"
+	case "https":
+		next.Scheme = "wss"
+	}
+	if (next.Scheme != "ws" && next.Scheme != "wss") || (URL.Scheme == "wss" && next.Scheme == "ws") {
+		return nil, ErrRedirect
+	}
+
+	// 同一主机的重定向保留地址中的认证信息, 重定向到其他主机时不再发送
+	// The credentials in the address are kept for redirects to the same host,
+	// and are no longer sent after being redirected to another host
+	if next.Hostname() != URL.Hostname() {
+		c.crossHost = true
+	} else if next.User == nil {
+		next.User = URL.User
+	}
+	c.target = next.String()
+	c.secWebsocketKey = ""
+	return next, nil
+}
+
+// 转换为 CookieJar 使用的 http 或者 https 地址
+// Converts to the http or https URL used by the CookieJar
+func cookieURL(URL *url.URL) *url.URL {
+	var u = *URL
+	switch u.Scheme {
+	case "wss", "https":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	return &u
+}
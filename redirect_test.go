@@ -0,0 +1,183 @@
+package gws
+
+import (
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRedirect(t *testing.T) {
+	var as = assert.New(t)
+
+	var requests = make(chan *http.Request, 8)
+	var upgrader = NewUpgrader(new(BuiltinEventHandler), nil)
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		if socket, err := upgrader.Upgrade(w, r); err == nil {
+			go socket.ReadLoop()
+		}
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/cookie", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !as.NoError(err) {
+		return
+	}
+	defer listener.Close()
+	go func() { _ = http.Serve(listener, mux) }()
+	var addr = listener.Addr().String()
+	var _, port, _ = net.SplitHostPort(addr)
+	mux.HandleFunc("/other-host", func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		http.Redirect(w, r, "http://localhost:"+port+"/new", http.StatusFound)
+	})
+
+	var dial = func(option *ClientOption) (*http.Response, error) {
+		client, resp, err := NewClient(new(BuiltinEventHandler), option)
+		if err == nil {
+			_ = client.NetConn().Close()
+		}
+		return resp, err
+	}
+
+	t.Run("follow", func(t *testing.T) {
+		_, err := dial(&ClientOption{Addr: "ws://" + addr + "/old", MaxRedirects: 1})
+		if as.NoError(err) {
+			as.Equal("/new", (<-requests).URL.Path)
+		}
+
+		resp, err := dial(&ClientOption{Addr: "ws://" + addr + "/old"})
+		as.Error(err)
+		as.Equal(http.StatusTemporaryRedirect, resp.StatusCode)
+
+		_, err = dial(&ClientOption{Addr: "ws://" + addr + "/loop", MaxRedirects: 3})
+		as.ErrorIs(err, ErrTooManyRedirects)
+
+		_, err = dial(&ClientOption{Addr: "ws://" + addr + "/empty", MaxRedirects: 3})
+		as.ErrorIs(err, ErrRedirect)
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		_, err := dial(&ClientOption{Addr: "ws://user:pass@" + addr + "/old", MaxRedirects: 1})
+		if as.NoError(err) {
+			username, password, ok := (<-requests).BasicAuth()
+			as.True(ok)
+			as.Equal("user", username)
+			as.Equal("pass", password)
+		}
+
+		_, err = dial(&ClientOption{
+			Addr:          "ws://user:pass@" + addr + "/new",
+			RequestHeader: http.Header{"Authorization": []string{"Bearer token"}},
+		})
+		if as.NoError(err) {
+			as.Equal("Bearer token", (<-requests).Header.Get("Authorization"))
+		}
+	})
+
+	t.Run("host override", func(t *testing.T) {
+		// 重定向到其他主机后不再使用 RequestHeader 中的 Host
+		// The Host in RequestHeader is no longer used after being redirected to another host
+		_, err := dial(&ClientOption{
+			Addr:          "ws://user:pass@" + addr + "/other-host",
+			MaxRedirects:  1,
+			RequestHeader: http.Header{"Host": []string{"virtual.example"}},
+		})
+		if as.NoError(err) {
+			var first = <-requests
+			as.Equal("virtual.example", first.Host)
+			_, _, ok := first.BasicAuth()
+			as.True(ok)
+
+			var second = <-requests
+			as.Equal("localhost:"+port, second.Host)
+			_, _, ok = second.BasicAuth()
+			as.False(ok)
+		}
+	})
+
+	t.Run("cookie jar", func(t *testing.T) {
+		jar, _ := cookiejar.New(nil)
+		_, err := dial(&ClientOption{Addr: "ws://" + addr + "/cookie", MaxRedirects: 1, Jar: jar})
+		if as.NoError(err) {
+			cookie, err := (<-requests).Cookie("session")
+			if as.NoError(err) {
+				as.Equal("abc", cookie.Value)
+			}
+		}
+		var cookies = jar.Cookies(&url.URL{Scheme: "http", Host: addr, Path: "/"})
+		if as.Equal(1, len(cookies)) {
+			as.Equal("session", cookies[0].Name)
+		}
+	})
+
+	t.Run("scheme", func(t *testing.T) {
+		var c = &connector{option: &ClientOption{}}
+		var redirect = func(from, location string) (*url.URL, error) {
+			URL, _ := url.Parse(from)
+			return c.redirect(URL, &http.Response{Header: http.Header{"Location": []string{location}}})
+		}
+
+		next, err := redirect("wss://a.example/connect", "https://a.example/v2")
+		if as.NoError(err) {
+			as.Equal("wss://a.example/v2", next.String())
+		}
+		as.False(c.crossHost)
+
+		next, err = redirect("ws://a.example/connect", "https://b.example/v2")
+		if as.NoError(err) {
+			as.Equal("wss", next.Scheme)
+		}
+		as.True(c.crossHost)
+
+		_, err = redirect("wss://a.example/connect", "ws://a.example/v2")
+		as.ErrorIs(err, ErrRedirect)
+		_, err = redirect("ws://a.example/connect", "ftp://a.example/v2")
+		as.ErrorIs(err, ErrRedirect)
+		_, err = redirect("ws://a.example/connect", "http://[::1")
+		as.Error(err)
+
+		// 重定向到其他主机后不再发送认证信息
+		// The credentials are no longer sent after being redirected to another host
+		c.option.RequestHeader = http.Header{"Authorization": []string{"Bearer token"}, "X-Trace": []string{"1"}}
+		r, _ := http.NewRequest(http.MethodGet, "ws://b.example/v2", nil)
+		c.setRequestHeader(r)
+		as.Equal("", r.Header.Get("Authorization"))
+		as.Equal("1", r.Header.Get("X-Trace"))
+
+		c.option.RequestHeader.Set("Host", "virtual.example")
+		c.setRequestHeader(r)
+		as.Equal("b.example", r.Host)
+
+		// 同一主机的重定向保留地址中的认证信息
+		// The credentials in the address are kept for redirects to the same host
+		c = &connector{option: &ClientOption{}}
+		next, err = redirect("ws://user:pass@a.example/connect", "http://a.example/v2")
+		if as.NoError(err) {
+			as.Equal("ws://user:pass@a.example/v2", next.String())
+		}
+		next, err = redirect("ws://user:pass@a.example/connect", "http://b.example/v2")
+		if as.NoError(err) {
+			as.Nil(next.User)
+		}
+
+		var u, _ = url.Parse("ws+unix:///tmp/app.sock:/connect")
+		as.False(c.shouldRedirect(u, &http.Response{StatusCode: http.StatusFound}))
+	})
+}
@@ -0,0 +1,110 @@
+package gws
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 复制升级请求, 只保留请求行, 请求头, 地址和 TLS 状态等元数据, 不包含请求体
+// 原请求的上下文在 Handler 返回后会被取消, 所以副本使用新的上下文, 只保留 PROXY 协议头部.
+// Copies the upgrade request, only metadata such as the request line, headers, addresses and the TLS state are kept,
+// the body is not included.
+// The context of the original request is canceled after the Handler returns, so the copy uses a new context
+// that only keeps the PROXY protocol header.
+func cloneRequest(r *http.Request) *http.Request {
+	var ctx = context.Background()
+	if header, ok := ProxyHeaderFromRequest(r); ok {
+		ctx = context.WithValue(ctx, proxyHeaderKey{}, header)
+	}
+	var req = &http.Request{
+		Method:     r.Method,
+		Proto:      r.Proto,
+		ProtoMajor: r.ProtoMajor,
+		ProtoMinor: r.ProtoMinor,
+		Header:     r.Header.Clone(),
+		Body:       http.NoBody,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		RequestURI: r.RequestURI,
+		TLS:        r.TLS,
+	}
+	if r.URL != nil {
+		var u = *r.URL
+		if r.URL.User != nil {
+			var user = *r.URL.User
+			u.User = &user
+		}
+		req.URL = &u
+	}
+	return req.WithContext(ctx)
+}
+
+// 解析客户端的真实 IP. 直连地址属于受信任的代理时, 从右向左遍历 Forwarded (优先) 或者 X-Forwarded-For,
+// 返回第一个不受信任的地址; 遇到无法解析的地址时返回最近一个受信任的代理.
+// Resolves the real IP of the client. If the direct address belongs to a trusted proxy, Forwarded (preferred)
+// or X-Forwarded-For is walked from right to left and the first untrusted address is returned;
+// if an address cannot be parsed, the nearest trusted proxy is returned.
+func resolveClientIP(r *http.Request, remoteAddr net.Addr, trusted []*net.IPNet) string {
+	var ip = remoteHost(remoteAddr)
+	if !containsIP(trusted, ip) {
+		return ip
+	}
+
+	var hops []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		hops = parseForwarded(values)
+	} else {
+		for _, line := range r.Header.Values("X-Forwarded-For") {
+			for _, item := range strings.Split(line, ",") {
+				hops = append(hops, strings.TrimSpace(item))
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		var hop = parseHopIP(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+		if !containsIP(trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// 解析 Forwarded 头部(RFC 7239)中的 for 参数, 没有 for 参数的元素记为空字符串
+// Parses the for parameters of the Forwarded header (RFC 7239), elements without a for parameter are recorded as empty strings
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, line := range values {
+		for _, element := range strings.Split(line, ",") {
+			var hop = ""
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hop = strings.Trim(v, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// 解析转发记录中的 IP, 去掉端口和 IPv6 的方括号, 无法解析时返回空字符串
+// Parses the IP of a forwarding record, the port and the brackets of IPv6 are removed,
+// returns an empty string if it cannot be parsed
+func parseHopIP(hop string) string {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package gws
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnRequest(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("upgrade", func(t *testing.T) {
+		var sockets = make(chan *Conn, 1)
+		var handler = new(webSocketMocker)
+		handler.onOpen = func(socket *Conn) { sockets <- socket }
+		var server = NewServer(handler, &ServerOption{TrustedProxies: []string{"127.0.0.1"}})
+		listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+		if !as.NoError(err) {
+			return
+		}
+		go server.RunListener(listener)
+
+		var header = http.Header{}
+		header.Set("Cookie", "token=abc")
+		header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:          "ws://" + listener.Addr().String() + "/chat?name=caspar",
+			RequestHeader: header,
+		})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Nil(client.Request())
+		as.Equal("127.0.0.1", client.ClientIP())
+
+		var socket = <-sockets
+		var r = socket.Request()
+		as.Equal("/chat", r.URL.Path)
+		as.Equal("caspar", r.URL.Query().Get("name"))
+		cookie, err := r.Cookie("token")
+		if as.NoError(err) {
+			as.Equal("abc", cookie.Value)
+		}
+		as.Equal(http.NoBody, r.Body)
+		as.NoError(r.Context().Err())
+		as.Equal("10.0.0.1", socket.ClientIP())
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		var header = &ProxyHeader{Version: 2}
+		var ctx, cancel = context.WithCancel(context.WithValue(context.Background(), proxyHeaderKey{}, header))
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "ws://user:pass@localhost/connect", nil)
+		r.Header.Set("X-Token", "1")
+		var req = cloneRequest(r)
+		cancel()
+		as.NoError(req.Context().Err())
+		h, ok := ProxyHeaderFromRequest(req)
+		as.True(ok)
+		as.Equal(header, h)
+
+		r.Header.Set("X-Token", "2")
+		r.URL.Path = "/"
+		r.URL.User = url.User("other")
+		as.Equal("1", req.Header.Get("X-Token"))
+		as.Equal("/connect", req.URL.Path)
+		as.Equal("user", req.URL.User.Username())
+	})
+
+	t.Run("client ip", func(t *testing.T) {
+		var trusted = parseCIDRs([]string{"10.0.0.0/8", "::1"}, defaultLogger)
+		var remote = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+		var resolve = func(remote net.Addr, kv ...string) string {
+			var r = &http.Request{Header: http.Header{}}
+			for i := 0; i+1 < len(kv); i += 2 {
+				r.Header.Add(kv[i], kv[i+1])
+			}
+			return resolveClientIP(r, remote, trusted)
+		}
+
+		// 不受信任的直连地址忽略转发头部
+		// Forwarding headers are ignored for untrusted direct addresses
+		as.Equal("192.0.2.1", resolve(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, "X-Forwarded-For", "203.0.113.7"))
+
+		as.Equal("10.0.0.1", resolve(remote))
+		as.Equal("203.0.113.7", resolve(remote, "X-Forwarded-For", "203.0.113.7"))
+		as.Equal("203.0.113.7", resolve(remote, "X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.2"))
+		as.Equal("203.0.113.7", resolve(remote, "X-Forwarded-For", "198.51.100.1", "X-Forwarded-For", "203.0.113.7, 10.0.0.2"))
+		as.Equal("10.0.0.3", resolve(remote, "X-Forwarded-For", "10.0.0.3, 10.0.0.2"))
+		as.Equal("10.0.0.2", resolve(remote, "X-Forwarded-For", "unknown, 10.0.0.2"))
+
+		// Forwarded 优先于 X-Forwarded-For
+		// Forwarded takes precedence over X-Forwarded-For
+		as.Equal("2001:db8::1", resolve(remote, "Forwarded", `for="[2001:db8::1]:4711";proto=https, For=10.0.0.2`, "X-Forwarded-For", "203.0.113.7"))
+		as.Equal("198.51.100.1", resolve(remote, "Forwarded", "for=198.51.100.1:80;by=10.0.0.1"))
+		as.Equal("10.0.0.1", resolve(remote, "Forwarded", "for=_hidden"))
+		as.Equal("10.0.0.1", resolve(remote, "Forwarded", "proto=https"))
+		as.Equal("203.0.113.7", resolve(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}, "X-Forwarded-For", "203.0.113.7"))
+	})
+}
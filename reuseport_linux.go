@@ -0,0 +1,37 @@
+package gws
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// 打开 n 个设置了 SO_REUSEPORT 的监听器, 由内核在监听器之间分配新的连接
+// Opens n listeners with SO_REUSEPORT set, the kernel distributes new connections among the listeners
+func listenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	var config = net.ListenConfig{Control: func(network, address string, conn syscall.RawConn) error {
+		var err error
+		if e := conn.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}); e != nil {
+			return e
+		}
+		return err
+	}}
+
+	var listeners = make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		listener, err := config.Listen(context.Background(), network, addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		// 端口为 0 时, 其余的监听器使用第一个监听器分配到的端口
+		// If the port is 0, the remaining listeners use the port assigned to the first listener
+		if i == 0 {
+			addr = listener.Addr().String()
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package gws
+
+import "net"
+
+// 非 Linux 平台不支持 SO_REUSEPORT 负载均衡, 只打开一个监听器
+// SO_REUSEPORT load balancing is not supported on non-Linux platforms, only one listener is opened
+func listenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
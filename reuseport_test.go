@@ -0,0 +1,82 @@
+package gws
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReusePort(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("listen", func(t *testing.T) {
+		listeners, err := listenReusePort("tcp", "127.0.0.1:0", 4)
+		if !as.NoError(err) {
+			return
+		}
+		defer closeListeners(listeners)
+		if runtime.GOOS == "linux" {
+			as.Equal(4, len(listeners))
+		}
+		for _, item := range listeners {
+			as.Equal(listeners[0].Addr().String(), item.Addr().String())
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		var handler = new(webSocketMocker)
+		handler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var server = NewServer(handler, &ServerOption{ReusePortListeners: 4})
+		var addr = "127.0.0.1:" + nextPort()
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var wg = &sync.WaitGroup{}
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			var client, _, err = NewClient(&webSocketMocker{onMessage: func(socket *Conn, message *Message) {
+				as.Equal("hello", message.Data.String())
+				wg.Done()
+			}}, &ClientOption{Addr: "ws://" + addr})
+			if !as.NoError(err) {
+				wg.Done()
+				continue
+			}
+			go client.ReadLoop()
+			as.NoError(client.WriteString("hello"))
+			defer client.NetConn().Close()
+		}
+		wg.Wait()
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		listeners, err := listenReusePort("tcp", "127.0.0.1:0", 2)
+		if !as.NoError(err) {
+			return
+		}
+		var server = NewServer(new(BuiltinEventHandler), nil)
+		var done = make(chan error, 1)
+		go func() { done <- server.runListeners(listeners, nil) }()
+		time.Sleep(50 * time.Millisecond)
+		closeListeners(listeners)
+		select {
+		case err = <-done:
+			as.NoError(err)
+		case <-time.After(time.Second):
+			as.Fail("runListeners did not return")
+		}
+	})
+
+	t.Run("unsupported network", func(t *testing.T) {
+		var server = NewServer(new(BuiltinEventHandler), &ServerOption{ReusePortListeners: 2})
+		_, err := server.listen("tcp9", "127.0.0.1:0")
+		as.Error(err)
+		_, err = server.listen("none", "127.0.0.1:0")
+		as.Error(err)
+	})
+}
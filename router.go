@@ -0,0 +1,76 @@
+package gws
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// 路由, 将请求路径映射到独立的升级器
+// Route, maps request paths to their own upgraders
+type route struct {
+	pattern  string
+	prefix   bool
+	upgrader *Upgrader
+}
+
+// 是否匹配请求路径, 以 "/*" 结尾的模式匹配该前缀下的所有路径
+// Whether the request path matches, patterns ending with "/*" match all paths under the prefix
+func (c *route) match(path string) bool {
+	if !c.prefix {
+		return path == c.pattern
+	}
+	return path == c.pattern || strings.HasPrefix(path, c.pattern+"/")
+}
+
+// Handle 注册路由, 请求路径匹配 pattern 时使用 handler 处理连接
+// pattern 可以是精确路径, 例如 "/chat"; 也可以以 "/*" 结尾匹配前缀, 例如 "/rooms/*" 匹配 "/rooms" 和 "/rooms/1".
+// 精确路径优先, 其次是最长的前缀. 注册路由后, 未匹配的请求在升级前响应 404.
+// override 用于覆盖该路由的配置(例如消息大小限制, 压缩和子协议), 修改的是服务器配置的副本, 可以为 nil.
+// 连接数限制, PROXY 协议, 可信代理, 读缓冲区大小, 请求读取超时等连接级别的配置以及统计指标由所有路由共享, 在路由中覆盖无效.
+// 需要在运行服务器之前调用.
+// Registers a route, connections whose request path matches pattern are handled by handler.
+// pattern can be an exact path such as "/chat", or end with "/*" to match a prefix,
+// e.g. "/rooms/*" matches "/rooms" and "/rooms/1".
+// Exact paths take precedence, followed by the longest prefix. Once routes are registered,
+// unmatched requests are answered with 404 before the upgrade.
+// override overrides the configuration of the route (such as payload limits, compression and subprotocols),
+// it modifies a copy of the server options and can be nil.
+// Connection-level options such as connection limits, PROXY protocol, trusted proxies, read buffer size
+// and request read timeouts, as well as metrics, are shared by all routes and cannot be overridden.
+// It must be called before running the server.
+func (c *Server) Handle(pattern string, handler Event, override func(option *ServerOption)) {
+	var upgrader = newUpgrader(handler, c.option.newRouteOption(override))
+	upgrader.admission = c.upgrader.admission
+
+	var r = &route{pattern: pattern, upgrader: upgrader}
+	if strings.HasSuffix(pattern, "/*") {
+		r.pattern, r.prefix = strings.TrimSuffix(pattern, "/*"), true
+	}
+	c.routes = append(c.routes, r)
+	sort.SliceStable(c.routes, func(i, j int) bool {
+		var a, b = c.routes[i], c.routes[j]
+		if a.prefix != b.prefix {
+			return !a.prefix
+		}
+		return len(a.pattern) > len(b.pattern)
+	})
+}
+
+// 根据请求路径选择升级器, 没有注册路由时使用默认的升级器
+// Selects the upgrader by the request path, the default upgrader is used if no routes are registered
+func (c *Server) match(r *http.Request) (*Upgrader, error) {
+	if len(c.routes) == 0 {
+		return c.upgrader, nil
+	}
+	var path = "/"
+	if r.URL != nil && r.URL.Path != "" {
+		path = r.URL.Path
+	}
+	for _, item := range c.routes {
+		if item.match(path) {
+			return item.upgrader, nil
+		}
+	}
+	return nil, &HandshakeError{StatusCode: http.StatusNotFound, Err: ErrRouteNotFound}
+}
@@ -0,0 +1,173 @@
+package gws
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	var as = assert.New(t)
+
+	var echo = new(webSocketMocker)
+	echo.onMessage = func(socket *Conn, message *Message) {
+		_ = socket.WriteMessage(message.Opcode, message.Bytes())
+	}
+	var server = NewServer(new(BuiltinEventHandler), &ServerOption{
+		MaxConnections: 2,
+		ResponseHeader: http.Header{"X-Server": []string{"gws"}},
+	})
+	server.Handle("/chat", echo, nil)
+	server.Handle("/rooms/*", new(BuiltinEventHandler), func(option *ServerOption) {
+		option.SubProtocols = []string{"room"}
+		option.ResponseHeader.Set("X-Route", "rooms")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:"+nextPort())
+	if !as.NoError(err) {
+		return
+	}
+	go server.RunListener(listener)
+	var addr = "ws://" + listener.Addr().String()
+
+	t.Run("exact", func(t *testing.T) {
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		var handler = new(webSocketMocker)
+		handler.onMessage = func(socket *Conn, message *Message) {
+			as.Equal("hello", message.Data.String())
+			wg.Done()
+		}
+		client, resp, err := NewClient(handler, &ClientOption{Addr: addr + "/chat"})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("gws", resp.Header.Get("X-Server"))
+		as.Equal("", resp.Header.Get("X-Route"))
+		go client.ReadLoop()
+		as.NoError(client.WriteString("hello"))
+		wg.Wait()
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		client, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: addr + "/rooms/1", SubProtocols: []string{"room"}})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("room", client.SubProtocol())
+		as.Equal("rooms", resp.Header.Get("X-Route"))
+		as.Equal("gws", resp.Header.Get("X-Server"))
+		as.Equal("", server.option.ResponseHeader.Get("X-Route"))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		var errs = make(chan error, 1)
+		var onError = server.OnError
+		server.OnError = func(conn net.Conn, err error) { errs <- err }
+		defer func() { server.OnError = onError }()
+
+		_, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: addr + "/chat/1"})
+		as.Error(err)
+		if as.NotNil(resp) {
+			as.Equal(http.StatusNotFound, resp.StatusCode)
+		}
+		as.ErrorIs(<-errs, ErrRouteNotFound)
+	})
+
+	t.Run("shared limits", func(t *testing.T) {
+		// 等待之前的连接释放名额
+		// Waits for the previous connections to release their slots
+		time.Sleep(100 * time.Millisecond)
+		as.Equal(int64(0), server.Metrics().ActiveConnections)
+
+		client1, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: addr + "/chat"})
+		if !as.NoError(err) {
+			return
+		}
+		defer client1.NetConn().Close()
+		go client1.ReadLoop()
+		client2, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: addr + "/rooms", SubProtocols: []string{"room"}})
+		if !as.NoError(err) {
+			return
+		}
+		defer client2.NetConn().Close()
+		go client2.ReadLoop()
+
+		_, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: addr + "/chat"})
+		as.Error(err)
+		if as.NotNil(resp) {
+			as.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+		}
+		as.Equal(int64(2), server.Metrics().ActiveConnections)
+	})
+
+	t.Run("shared config", func(t *testing.T) {
+		var logger = new(countingLogger)
+		var s = NewServer(new(BuiltinEventHandler), &ServerOption{
+			Logger:         logger,
+			ProxyProtocol:  ProxyProtocol{Enabled: true},
+			TrustedProxies: []string{"10.0.0.0/8"},
+		})
+		as.Equal(int64(1), atomic.LoadInt64(&logger.count))
+
+		s.Handle("/a", new(BuiltinEventHandler), func(option *ServerOption) {
+			option.ReadBufferSize = 64 * 1024
+			option.ReadMaxPayloadSize = 1024
+			option.PermessageDeflate.Enabled = true
+		})
+		// 连接级别的配置不会重复校验
+		// Connection-level options are not validated again
+		as.Equal(int64(1), atomic.LoadInt64(&logger.count))
+
+		var option = s.routes[0].upgrader.option
+		as.Equal(1024, option.ReadMaxPayloadSize)
+		as.Equal(1024, option.config.ReadMaxPayloadSize)
+		as.Equal(defaultCompressorPoolSize, option.PermessageDeflate.PoolSize)
+		as.Equal(defaultReadBufferSize, option.ReadBufferSize)
+		as.Equal(defaultReadBufferSize, option.config.ReadBufferSize)
+		as.True(option.config.brPool == s.option.config.brPool)
+		as.True(option.config.metrics == s.option.config.metrics)
+		as.Equal(s.option.config.trustedProxies, option.config.trustedProxies)
+		as.Equal(s.option.ProxyProtocol.trusted, option.ProxyProtocol.trusted)
+	})
+
+	t.Run("match", func(t *testing.T) {
+		var s = NewServer(new(BuiltinEventHandler), nil)
+		upgrader, err := s.match(&http.Request{})
+		as.NoError(err)
+		as.Equal(s.GetUpgrader(), upgrader)
+
+		s.Handle("/a/*", new(BuiltinEventHandler), nil)
+		s.Handle("/a/b/*", new(BuiltinEventHandler), nil)
+		s.Handle("/a/b", new(BuiltinEventHandler), nil)
+		s.Handle("/", new(BuiltinEventHandler), nil)
+		as.Equal([]string{"/a/b", "/", "/a/b", "/a"}, []string{s.routes[0].pattern, s.routes[1].pattern, s.routes[2].pattern, s.routes[3].pattern})
+
+		var find = func(path string) *route {
+			r, _ := http.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+			upgrader, err := s.match(r)
+			if err != nil {
+				return nil
+			}
+			for _, item := range s.routes {
+				if item.upgrader == upgrader {
+					return item
+				}
+			}
+			return nil
+		}
+		as.False(find("/a/b").prefix)
+		as.True(find("/a/b/c").prefix)
+		as.Equal("/a/b", find("/a/b/c").pattern)
+		as.Equal("/a", find("/a/bc").pattern)
+		as.Equal("/a", find("/a").pattern)
+		as.Equal("/", find("").pattern)
+		as.Nil(find("/b"))
+	})
+}
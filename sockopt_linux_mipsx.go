@@ -0,0 +1,7 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package gws
+
+// SO_REUSEPORT 的值, syscall 包在部分架构上没有定义
+// Value of SO_REUSEPORT, which is not defined by the syscall package on some architectures
+const soReusePort = 0x200
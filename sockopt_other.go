@@ -0,0 +1,7 @@
+//go:build !unix
+
+package gws
+
+// 不支持 unixpacket 的平台不会截断数据包
+// Packets are never truncated on platforms without unixpacket support
+const msgTrunc = 0
@@ -0,0 +1,9 @@
+//go:build unix
+
+package gws
+
+import "syscall"
+
+// 数据包被截断的标志
+// Flag indicating that a packet was truncated
+const msgTrunc = syscall.MSG_TRUNC
@@ -0,0 +1,59 @@
+package gws
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/lxzan/gws/internal"
+)
+
+// 解析 Sec-WebSocket-Protocol, 支持多个头部行和逗号分隔的列表
+// Parses Sec-WebSocket-Protocol, supporting multiple header lines and comma-separated lists
+func parseSubProtocols(h http.Header) []string {
+	return internal.Split(strings.Join(h.Values(internal.SecWebSocketProtocol.Key), ","), ",")
+}
+
+// 根据协商的子协议选择事件处理器, 没有匹配时使用默认的处理器
+// Selects the event handler by the negotiated subprotocol, the default handler is used if there is no match
+func selectEventHandler(handler Event, handlers map[string]Event, subprotocol string) Event {
+	if v, ok := handlers[subprotocol]; ok && subprotocol != "" {
+		return v
+	}
+	return handler
+}
+
+// 服务端选择子协议, 选中的子协议必须是客户端提议的
+// The server selects the subprotocol, the selected subprotocol must be offered by the client
+func (c *Upgrader) selectSubProtocol(r *http.Request) (string, error) {
+	var offered = parseSubProtocols(r.Header)
+	if c.option.SelectSubProtocol != nil {
+		subprotocol, err := c.option.SelectSubProtocol(r, offered)
+		if err != nil {
+			return "", err
+		}
+		if subprotocol != "" && !internal.InCollection(subprotocol, offered) {
+			return "", ErrSubprotocolNegotiation
+		}
+		return subprotocol, nil
+	}
+	if len(c.option.SubProtocols) == 0 {
+		return "", nil
+	}
+	var subprotocol = internal.GetIntersectionElem(c.option.SubProtocols, offered)
+	if subprotocol == "" {
+		return "", ErrSubprotocolNegotiation
+	}
+	return subprotocol, nil
+}
+
+// 客户端提议的子协议, 包含 RequestHeader 中设置的和 SubProtocols 中配置的
+// Subprotocols offered by the client, including those set in RequestHeader and configured in SubProtocols
+func (c *ClientOption) offeredSubProtocols() []string {
+	var offered = parseSubProtocols(c.RequestHeader)
+	for _, item := range c.SubProtocols {
+		if item = strings.TrimSpace(item); item != "" && !internal.InCollection(item, offered) {
+			offered = append(offered, item)
+		}
+	}
+	return offered
+}
@@ -36,20 +36,14 @@ func serveWebSocket(
 		subprotocol: subprotocol,
 		writeQueue:  workerQueue{maxConcurrency: 1},
 		readQueue:   make(channel, 8),
-		pd:          pd,
 	}
 	if compressEnabled {
+		var pool *deflaterPool
 		if isServer {
-			socket.deflater = new(deflaterPool).initialize(pd, config.ReadMaxPayloadSize).Select()
-			if pd.ServerContextTakeover {
-				socket.cpsWindow.initialize(config.cswPool, pd.ServerMaxWindowBits)
-			}
-			if pd.ClientContextTakeover {
-				socket.dpsWindow.initialize(config.dswPool, pd.ClientMaxWindowBits)
-			}
-		} else {
-			socket.deflater = new(deflater).initialize(false, pd, config.ReadMaxPayloadSize)
+			pool = new(deflaterPool).initialize(pd, config.ReadMaxPayloadSize)
 		}
+		var ext = newPermessageDeflateExtension(isServer, pd, pool, config.ReadMaxPayloadSize)
+		socket.setExtensions([]negotiatedExtension{{rsv: ext.RSV(), handler: &deflateHandler{ext: ext, pd: pd}}})
 	}
 	return socket
 }
@@ -0,0 +1,212 @@
+package gws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// CertificateFile 证书和私钥文件
+	// Certificate and private key files
+	CertificateFile struct {
+		CertFile string
+		KeyFile  string
+	}
+
+	// PeerIdentity 经过验证的对端证书身份 (mTLS)
+	// Identity of the verified peer certificate (mTLS)
+	PeerIdentity struct {
+		// 对端证书
+		// Peer certificate
+		Certificate *x509.Certificate
+
+		// 验证通过的证书链, 从对端证书开始到根证书
+		// Verified certificate chain, from the peer certificate to the root
+		Chain []*x509.Certificate
+
+		// 证书主题的通用名称
+		// Common name of the certificate subject
+		CommonName string
+
+		// 主题备用名称
+		// Subject alternative names
+		DNSNames       []string
+		EmailAddresses []string
+		URIs           []*url.URL
+	}
+
+	// 证书仓库, 按 SNI 选择证书, 证书文件变化后重新加载
+	// Certificate store, selects certificates by SNI and reloads them after the files change
+	certificateStore struct {
+		files    []CertificateFile
+		interval time.Duration
+		logger   Logger
+
+		// 下一次检查文件的时间 (UnixNano), 原子读取, 未到时间的握手不需要加锁
+		// Time of the next file check (UnixNano), read atomically, so handshakes before it do not take the lock
+		nextCheck int64
+
+		mu       sync.Mutex
+		modTimes []time.Time
+
+		// 当前的证书, 类型为 []*tls.Certificate
+		// Current certificates, of type []*tls.Certificate
+		certs atomic.Value
+	}
+)
+
+// 创建证书仓库, 首次加载失败时返回错误
+// Creates a certificate store, returns an error if the first load fails
+func newCertificateStore(files []CertificateFile, interval time.Duration, logger Logger) (*certificateStore, error) {
+	var c = &certificateStore{
+		files:     files,
+		interval:  interval,
+		logger:    logger,
+		nextCheck: time.Now().Add(interval).UnixNano(),
+		modTimes:  make([]time.Time, len(files)),
+	}
+	var certs = make([]*tls.Certificate, len(files))
+	for i, item := range files {
+		cert, err := loadCertificate(item)
+		if err != nil {
+			return nil, err
+		}
+		certs[i], c.modTimes[i] = cert, modTime(item)
+	}
+	c.certs.Store(certs)
+	return c, nil
+}
+
+// 加载证书并解析叶子证书, 避免每次握手时重复解析
+// Loads the certificate and parses the leaf, avoiding parsing it again on every handshake
+func loadCertificate(file CertificateFile) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// 证书和私钥文件中较晚的修改时间
+// The later modification time of the certificate and private key files
+func modTime(file CertificateFile) time.Time {
+	var t time.Time
+	for _, name := range []string{file.CertFile, file.KeyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t
+}
+
+// 检查证书文件是否变化, 每个间隔最多检查一次. 重新加载失败时继续使用旧的证书.
+// Checks whether the certificate files have changed, at most once per interval.
+// The old certificate is kept if reloading fails.
+func (c *certificateStore) reload() {
+	if time.Now().UnixNano() < atomic.LoadInt64(&c.nextCheck) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now = time.Now()
+	if now.UnixNano() < atomic.LoadInt64(&c.nextCheck) {
+		return
+	}
+	atomic.StoreInt64(&c.nextCheck, now.Add(c.interval).UnixNano())
+
+	var certs = c.certs.Load().([]*tls.Certificate)
+	var updated []*tls.Certificate
+	for i, item := range c.files {
+		var t = modTime(item)
+		if t.Equal(c.modTimes[i]) {
+			continue
+		}
+		cert, err := loadCertificate(item)
+		if err != nil {
+			c.logger.Error("gws: failed to reload certificate " + item.CertFile + ": " + err.Error())
+			continue
+		}
+		if updated == nil {
+			updated = append([]*tls.Certificate{}, certs...)
+		}
+		updated[i], c.modTimes[i] = cert, t
+	}
+	if updated != nil {
+		c.certs.Store(updated)
+	}
+}
+
+// 按 SNI 选择证书, 没有匹配时使用第一个证书
+// Selects the certificate by SNI, the first certificate is used if none matches
+func (c *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.withConfig(nil, nil)(hello)
+}
+
+// 与 TLS 配置中已有的证书组合. 优先调用 getCertificate, 返回 nil 时按 SNI 依次从证书文件和 certificates 中选择,
+// 都不匹配时使用第一个证书文件. getCertificate 返回的错误会中止握手.
+// Combines with the certificates already in the TLS configuration. getCertificate is called first, if it returns nil
+// the certificate is selected by SNI from the certificate files and then certificates,
+// the first certificate file is used if none matches. An error returned by getCertificate aborts the handshake.
+func (c *certificateStore) withConfig(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+	certificates []tls.Certificate,
+) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if getCertificate != nil {
+			if cert, err := getCertificate(hello); err != nil || cert != nil {
+				return cert, err
+			}
+		}
+		c.reload()
+		var certs = c.certs.Load().([]*tls.Certificate)
+		for _, cert := range certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+		for i := range certificates {
+			if hello.SupportsCertificate(&certificates[i]) == nil {
+				return &certificates[i], nil
+			}
+		}
+		return certs[0], nil
+	}
+}
+
+// 从 TLS 连接状态中获取经过验证的对端身份, 没有验证过的证书链时返回 nil
+// Gets the verified peer identity from the TLS connection state, returns nil if there is no verified chain
+func newPeerIdentity(state *tls.ConnectionState) *PeerIdentity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	var chain = state.VerifiedChains[0]
+	var cert = chain[0]
+	return &PeerIdentity{
+		Certificate:    cert,
+		Chain:          chain,
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+	}
+}
+
+// PeerIdentityFromRequest 获取请求中经过验证的客户端证书身份 (mTLS), 可以在 Authorize 中使用
+// Gets the verified client certificate identity (mTLS) of the request, can be used in Authorize
+func PeerIdentityFromRequest(r *http.Request) (*PeerIdentity, bool) {
+	var identity = newPeerIdentity(r.TLS)
+	return identity, identity != nil
+}
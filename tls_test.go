@@ -0,0 +1,245 @@
+package gws
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 签发测试证书, parent 为 nil 时自签名
+// Issues a test certificate, self-signed if parent is nil
+func newTestCertificate(template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		panic(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestTLS(t *testing.T) {
+	var as = assert.New(t)
+	var dir = t.TempDir()
+
+	ca, caKey, caPEM, _ := newTestCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gws ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	var pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	var writeServerCertificate = func(name string, serial int64) CertificateFile {
+		_, _, certPEM, keyPEM := newTestCertificate(&x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca, caKey)
+		var file = CertificateFile{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+		_ = os.WriteFile(file.CertFile, certPEM, 0600)
+		_ = os.WriteFile(file.KeyFile, keyPEM, 0600)
+		return file
+	}
+	var fileA = writeServerCertificate("a.example", 10)
+	var fileB = writeServerCertificate("b.example", 20)
+
+	spiffe, _ := url.Parse("spiffe://example/client")
+	_, _, clientPEM, clientKeyPEM := newTestCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(30),
+		Subject:      pkix.Name{CommonName: "client"},
+		URIs:         []*url.URL{spiffe},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	clientCert, _ := tls.X509KeyPair(clientPEM, clientKeyPEM)
+
+	var identities = make(chan *PeerIdentity, 1)
+	var sockets = make(chan *Conn, 1)
+	var handler = new(webSocketMocker)
+	handler.onOpen = func(socket *Conn) { sockets <- socket }
+	var server = NewServer(handler, &ServerOption{
+		TlsConfig:                 &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool},
+		TlsCertificateFiles:       []CertificateFile{fileB},
+		CertificateReloadInterval: 10 * time.Millisecond,
+		AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+			identity, _ := PeerIdentityFromRequest(r)
+			identities <- identity
+			return nil
+		},
+	})
+	var addr = "127.0.0.1:" + nextPort()
+	go server.RunTLS(addr, fileA.CertFile, fileA.KeyFile)
+	time.Sleep(100 * time.Millisecond)
+
+	var dial = func(serverName string, certs ...tls.Certificate) (*Conn, error) {
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:      "wss://" + addr,
+			TlsConfig: &tls.Config{ServerName: serverName, RootCAs: pool, Certificates: certs},
+		})
+		return client, err
+	}
+
+	t.Run("sni", func(t *testing.T) {
+		for _, name := range []string{"a.example", "b.example"} {
+			client, err := dial(name)
+			if !as.NoError(err) {
+				return
+			}
+			as.Equal(name, client.TLSState().PeerCertificates[0].Subject.CommonName)
+			as.Nil(<-identities)
+			as.Nil((<-sockets).PeerIdentity())
+			_ = client.NetConn().Close()
+		}
+
+		// 没有匹配的名称时使用默认证书
+		// The default certificate is used if no name matches
+		_, err := dial("c.example")
+		as.Error(err)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writeServerCertificate("b.example", 21)
+		var later = time.Now().Add(time.Minute)
+		_ = os.Chtimes(fileB.CertFile, later, later)
+		time.Sleep(20 * time.Millisecond)
+
+		client, err := dial("b.example")
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal(int64(21), client.TLSState().PeerCertificates[0].SerialNumber.Int64())
+		<-identities
+		<-sockets
+		_ = client.NetConn().Close()
+
+		// 重新加载失败时继续使用旧的证书
+		// The old certificate is kept if reloading fails
+		_ = os.WriteFile(fileA.CertFile, []byte("invalid"), 0600)
+		_ = os.Chtimes(fileA.CertFile, later, later)
+		time.Sleep(20 * time.Millisecond)
+		client, err = dial("a.example")
+		if as.NoError(err) {
+			as.Equal(int64(10), client.TLSState().PeerCertificates[0].SerialNumber.Int64())
+			<-identities
+			<-sockets
+			_ = client.NetConn().Close()
+		}
+	})
+
+	t.Run("no lock before the next check", func(t *testing.T) {
+		store, err := newCertificateStore([]CertificateFile{fileB}, time.Minute, defaultLogger)
+		if !as.NoError(err) {
+			return
+		}
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		var done = make(chan *tls.Certificate, 1)
+		go func() {
+			cert, _ := store.getCertificate(&tls.ClientHelloInfo{ServerName: "b.example"})
+			done <- cert
+		}()
+		select {
+		case cert := <-done:
+			as.NotNil(cert)
+		case <-time.After(time.Second):
+			as.Fail("the lock is taken before the next check")
+		}
+	})
+
+	t.Run("user certificates", func(t *testing.T) {
+		var fileC, fileD = writeServerCertificate("c.example", 40), writeServerCertificate("d.example", 50)
+		certC, _ := tls.LoadX509KeyPair(fileC.CertFile, fileC.KeyFile)
+		certD, _ := tls.LoadX509KeyPair(fileD.CertFile, fileD.KeyFile)
+		var server = NewServer(new(BuiltinEventHandler), &ServerOption{
+			TlsConfig: &tls.Config{
+				Certificates: []tls.Certificate{certD},
+				GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					if hello.ServerName == "c.example" {
+						return &certC, nil
+					}
+					return nil, nil
+				},
+			},
+		})
+		var addr = "127.0.0.1:" + nextPort()
+		go server.RunTLS(addr, fileB.CertFile, fileB.KeyFile)
+		time.Sleep(100 * time.Millisecond)
+
+		// GetCertificate 优先, 返回 nil 时从证书文件和 Certificates 中选择
+		// GetCertificate comes first, the certificate files and Certificates are used if it returns nil
+		for _, name := range []string{"c.example", "d.example", "b.example"} {
+			client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+				Addr:      "wss://" + addr,
+				TlsConfig: &tls.Config{ServerName: name, RootCAs: pool},
+			})
+			if as.NoError(err) {
+				as.Equal(name, client.TLSState().PeerCertificates[0].Subject.CommonName)
+				_ = client.NetConn().Close()
+			}
+		}
+		as.Equal(1, len(server.option.TlsConfig.Certificates))
+	})
+
+	t.Run("mtls", func(t *testing.T) {
+		client, err := dial("b.example", clientCert)
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+
+		var identity = <-identities
+		if as.NotNil(identity) {
+			as.Equal("client", identity.CommonName)
+			as.Equal("spiffe://example/client", identity.URIs[0].String())
+			as.Equal(2, len(identity.Chain))
+			as.Equal("gws ca", identity.Chain[1].Subject.CommonName)
+		}
+		var socket = <-sockets
+		if as.NotNil(socket.PeerIdentity()) {
+			as.Equal("client", socket.PeerIdentity().CommonName)
+		}
+		if as.NotNil(client.PeerIdentity()) {
+			as.Equal("b.example", client.PeerIdentity().CommonName)
+		}
+	})
+
+	t.Run("no tls", func(t *testing.T) {
+		server, client := net.Pipe()
+		var socket = &Conn{conn: server}
+		as.Nil(socket.TLSState())
+		as.Nil(socket.PeerIdentity())
+		_ = client.Close()
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		_, err := newCertificateStore([]CertificateFile{{CertFile: filepath.Join(dir, "none.crt")}}, time.Second, defaultLogger)
+		as.Error(err)
+	})
+}
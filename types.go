@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"runtime"
 	"unsafe"
 
@@ -50,6 +51,45 @@ func (c *CloseError) Error() string {
 	return fmt.Sprintf("gws: connection closed, code=%d, reason=%s", c.Code, string(c.Reason))
 }
 
+// HandshakeError 握手拒绝响应, 由升级器写入客户端以替代默认的 400 响应
+// Handshake rejection response, written to the client by the upgrader instead of the default 400 response
+type HandshakeError struct {
+	// HTTP 状态码, 非法时使用 400
+	// HTTP status code, 400 is used if it is invalid
+	StatusCode int
+
+	// 额外的响应头, 例如 WWW-Authenticate, Retry-After
+	// Additional response headers, such as WWW-Authenticate, Retry-After
+	Header http.Header
+
+	// 响应体, 为空时使用错误信息
+	// Response body, the error message is used if it is empty
+	Body []byte
+
+	// 原始错误
+	// Underlying error
+	Err error
+}
+
+// NewHandshakeError 创建握手拒绝响应
+// Creates a handshake rejection response
+func NewHandshakeError(statusCode int, body string) *HandshakeError {
+	return &HandshakeError{StatusCode: statusCode, Header: http.Header{}, Body: []byte(body)}
+}
+
+// Error 握手拒绝的描述
+// Returns a description of the handshake rejection
+func (c *HandshakeError) Error() string {
+	if c.Err != nil {
+		return c.Err.Error()
+	}
+	return fmt.Sprintf("gws: handshake rejected, status=%d", c.StatusCode)
+}
+
+// Unwrap 返回原始错误
+// Returns the underlying error
+func (c *HandshakeError) Unwrap() error { return c.Err }
+
 var (
 	errEmpty = errors.New("")
 
@@ -57,6 +97,10 @@ var (
 	// Failure to pass forensic authentication
 	ErrUnauthorized = errors.New("gws: unauthorized")
 
+	// ErrOriginNotAllowed 请求来源未通过检查
+	// The origin of the request is not allowed
+	ErrOriginNotAllowed = errors.New("gws: origin not allowed")
+
 	// ErrHandshake 握手错误, 请求头未通过校验
 	// Handshake error, request header does not pass checksum.
 	ErrHandshake = errors.New("gws: handshake error")
@@ -65,6 +109,10 @@ var (
 	// Compression extension negotiation failed, please try to disable compression.
 	ErrCompressionNegotiation = errors.New("gws: invalid compression negotiation")
 
+	// ErrExtensionNegotiation 拓展协商失败, 服务端响应了未提议的拓展或者参数不合法
+	// Extension negotiation failed, the server responded with an extension that was not offered or invalid parameters.
+	ErrExtensionNegotiation = errors.New("gws: extension negotiation failed")
+
 	// ErrSubprotocolNegotiation 子协议协商失败
 	// Sub-protocol negotiation failed
 	ErrSubprotocolNegotiation = errors.New("gws: sub-protocol negotiation failed")
@@ -77,6 +125,42 @@ var (
 	// message is too large
 	ErrMessageTooLarge = errors.New("gws: message too large")
 
+	// ErrDecompressionRatio 解压率超出限制
+	// Decompression ratio exceeds the limit
+	ErrDecompressionRatio = errors.New("gws: decompression ratio exceeded")
+
+	// ErrDecompressionRate 解压速率超出限制
+	// Decompression rate exceeds the limit
+	ErrDecompressionRate = errors.New("gws: decompression rate exceeded")
+
+	// ErrConnectionLimit 连接数超出限制
+	// The number of connections exceeds the limit
+	ErrConnectionLimit = errors.New("gws: too many connections")
+
+	// ErrConnectionLimitPerIP 单个 IP 的连接数超出限制
+	// The number of connections from a single IP exceeds the limit
+	ErrConnectionLimitPerIP = errors.New("gws: too many connections from the same ip")
+
+	// ErrHandshakeRate 单个 IP 的握手速率超出限制
+	// The handshake rate of a single IP exceeds the limit
+	ErrHandshakeRate = errors.New("gws: handshake rate exceeded")
+
+	// ErrRequestHeaderTooLarge 请求头超出 MaxHeaderBytes
+	// The request header exceeds MaxHeaderBytes
+	ErrRequestHeaderTooLarge = errors.New("gws: request header too large")
+
+	// ErrRequestTimeout 未能在 HandshakeReadTimeout 内读取请求
+	// The request was not read within HandshakeReadTimeout
+	ErrRequestTimeout = errors.New("gws: request timeout")
+
+	// ErrProxyProtocol PROXY 协议头部缺失或者不合法
+	// The PROXY protocol header is missing or invalid
+	ErrProxyProtocol = errors.New("gws: invalid proxy protocol header")
+
+	// ErrRouteNotFound 请求路径没有匹配的路由
+	// No route matches the request path
+	ErrRouteNotFound = errors.New("gws: route not found")
+
 	// ErrConnClosed 连接已关闭
 	// Connection closed
 	ErrConnClosed = net.ErrClosed
@@ -84,6 +168,22 @@ var (
 	// ErrUnsupportedProtocol 不支持的网络协议
 	// Unsupported network protocols
 	ErrUnsupportedProtocol = errors.New("gws: unsupported protocol")
+
+	// ErrReconnectBufferFull 断线期间缓存的消息已满
+	// The buffer of messages sent while disconnected is full
+	ErrReconnectBufferFull = errors.New("gws: reconnect buffer full")
+
+	// ErrTooManyRedirects 握手重定向次数超过 ClientOption.MaxRedirects
+	// The number of handshake redirects exceeds ClientOption.MaxRedirects
+	ErrTooManyRedirects = errors.New("gws: too many redirects")
+
+	// ErrRedirect 非法的握手重定向, 例如缺少 Location, 不支持的协议或者从 wss 降级到 ws
+	// Invalid handshake redirect, such as a missing Location, an unsupported scheme or a downgrade from wss to ws
+	ErrRedirect = errors.New("gws: invalid redirect")
+
+	// ErrPacketTooLarge unixpacket 数据包超过最大长度
+	// The unixpacket packet exceeds the maximum size
+	ErrPacketTooLarge = errors.New("gws: packet too large")
 )
 
 type Event interface {
@@ -160,6 +260,12 @@ func (c *frameHeader) GetRSV3() bool {
 	return ((*c)[0] << 3 >> 7) == 1
 }
 
+// GetRSV 返回 RSV1, RSV2, RSV3 三个保留位
+// Returns the RSV1, RSV2 and RSV3 reserved bits
+func (c *frameHeader) GetRSV() uint8 {
+	return (*c)[0] & (RSV1 | RSV2 | RSV3)
+}
+
 // GetOpcode 返回操作码
 // Returns the opcode
 func (c *frameHeader) GetOpcode() Opcode {
@@ -275,9 +381,9 @@ func (c *frameHeader) GetMaskKey() []byte {
 }
 
 type Message struct {
-	// 是否压缩
-	// if the message is compressed
-	compressed bool
+	// 第一帧的保留位, 表示消息经过了哪些拓展的编码
+	// Reserved bits of the first frame, indicating which extensions encoded the message
+	rsv uint8
 
 	// 操作码
 	// opcode of the message
@@ -313,9 +419,9 @@ type continuationFrame struct {
 	// Indicates if the frame is initialized
 	initialized bool
 
-	// 是否压缩
-	// Indicates if the frame is compressed
-	compressed bool
+	// 第一帧的保留位
+	// Reserved bits of the first frame
+	rsv uint8
 
 	// 操作码
 	// The opcode of the frame
@@ -330,7 +436,7 @@ type continuationFrame struct {
 // Resets the state of the continuation frame
 func (c *continuationFrame) reset() {
 	c.initialized = false
-	c.compressed = false
+	c.rsv = 0
 	c.opcode = 0
 	c.buffer = nil
 }
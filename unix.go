@@ -0,0 +1,194 @@
+package gws
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Unix 域套接字的网络类型
+	// Network types of Unix domain sockets
+	networkUnix       = "unix"
+	networkUnixPacket = "unixpacket"
+
+	// 通过 Unix 域套接字连接的 URL 协议, 例如 ws+unix:///tmp/app.sock:/connect
+	// URL scheme of connections over Unix domain sockets, e.g. ws+unix:///tmp/app.sock:/connect
+	schemeUnix = "ws+unix"
+
+	// unixpacket 单个数据包的最大长度, 不小于 Linux 默认的发送缓冲区
+	// Maximum size of a single unixpacket packet, no less than the default send buffer of Linux
+	maxPacketSize = 256 * 1024
+)
+
+type (
+	// unixpacket 连接, 每次从套接字读取完整的数据包, 上层的小缓冲区读取不会截断数据包
+	// unixpacket connection, whole packets are read from the socket,
+	// so small reads from the upper layers do not truncate packets
+	packetConn struct {
+		*net.UnixConn
+		buf  *bytes.Buffer
+		data []byte
+	}
+
+	// 监听器关闭时删除套接字文件
+	// Removes the socket file when the listener is closed
+	unixListener struct {
+		net.Listener
+		path string
+		once sync.Once
+	}
+)
+
+// unixpacket 连接使用 packetConn 包装, 其他连接原样返回
+// unixpacket connections are wrapped with packetConn, other connections are returned as is
+func wrapPacketConn(conn net.Conn) net.Conn {
+	if uc, ok := conn.(*net.UnixConn); ok && uc.LocalAddr().Network() == networkUnixPacket {
+		return &packetConn{UnixConn: uc}
+	}
+	return conn
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		if err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+	var n = copy(p, c.data)
+	c.data = c.data[n:]
+	if len(c.data) == 0 {
+		binaryPool.Put(c.buf)
+		c.buf, c.data = nil, nil
+	}
+	return n, nil
+}
+
+// 读取一个完整的数据包, 缓冲区在数据读完后归还内存池
+// Reads a whole packet, the buffer is returned to the pool after the data has been consumed
+func (c *packetConn) readPacket() error {
+	var buf = binaryPool.Get(maxPacketSize)
+	var p = buf.Bytes()[:maxPacketSize]
+	n, _, flags, _, err := c.UnixConn.ReadMsgUnix(p, nil)
+	if err == nil && flags&msgTrunc != 0 {
+		err = ErrPacketTooLarge
+	}
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		binaryPool.Put(buf)
+		return err
+	}
+	c.buf, c.data = buf, p[:n]
+	return nil
+}
+
+func (c *unixListener) Close() error {
+	var err = c.Listener.Close()
+	c.once.Do(func() { _ = os.Remove(c.path) })
+	return err
+}
+
+// 是否为 Unix 域套接字
+// Whether it is a Unix domain socket
+func isUnixNetwork(network string) bool {
+	return network == networkUnix || network == networkUnixPacket
+}
+
+// 是否为抽象命名空间的套接字(Linux), 不对应文件
+// Whether it is a socket in the abstract namespace (Linux), which has no file
+func isAbstractSocket(addr string) bool {
+	return addr == "" || addr[0] == '@'
+}
+
+// 删除残留的套接字文件. 套接字仍在监听时保留文件, 由 net.Listen 返回地址已被占用的错误.
+// Removes a leftover socket file. The file is kept if the socket is still listening,
+// in which case net.Listen returns an address-in-use error.
+func removeStaleSocket(network, addr string) error {
+	info, err := os.Lstat(addr)
+	if err != nil {
+		return ignoreNotExist(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.DialTimeout(network, addr, time.Second); err == nil {
+		_ = conn.Close()
+		return nil
+	}
+	return ignoreNotExist(os.Remove(addr))
+}
+
+func ignoreNotExist(err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 监听 Unix 域套接字, 删除残留的套接字文件并设置文件权限. 监听器关闭时会删除套接字文件.
+// Listens on a Unix domain socket, removes the leftover socket file and sets the file permissions.
+// The socket file is removed when the listener is closed.
+func (c *Server) listenUnix(network, addr string) (net.Listener, error) {
+	var abstract = isAbstractSocket(addr)
+	if !abstract {
+		if err := removeStaleSocket(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	if abstract || c.option.UnixSocketMode == 0 {
+		return net.Listen(network, addr)
+	}
+	return listenUnixMode(network, addr, c.option.UnixSocketMode)
+}
+
+// 在同一目录下只有当前用户可以访问的临时目录中创建套接字并设置权限, 然后链接到目标路径,
+// 避免套接字在设置权限之前以 umask 决定的权限被连接. 目标路径已存在时返回错误.
+// Creates the socket in a temporary directory next to the target that only the current user can access,
+// sets the permissions, then links it to the target path, so that the socket can never be connected to
+// with the permissions determined by umask. An error is returned if the target path already exists.
+func listenUnixMode(network, addr string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(addr), ".gws-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var tmp = filepath.Join(dir, "gws.sock")
+	listener, err := net.Listen(network, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(tmp, mode); err == nil {
+		err = os.Link(tmp, addr)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: listener, path: addr}, nil
+}
+
+// 解析 ws+unix URL, 冒号之前是套接字路径, 之后是请求路径, 例如 ws+unix:///tmp/app.sock:/connect?id=1
+// 返回套接字路径和发送握手请求使用的 URL.
+// Parses a ws+unix URL, the part before the colon is the socket path and the part after it is the request path,
+// e.g. ws+unix:///tmp/app.sock:/connect?id=1
+// Returns the socket path and the URL used to send the handshake request.
+func parseUnixURL(URL *url.URL) (string, string, error) {
+	var socketPath, path, _ = strings.Cut(URL.Host+URL.Path, ":")
+	if socketPath == "" {
+		return "", "", ErrUnsupportedProtocol
+	}
+	if path == "" {
+		path = "/"
+	}
+	var target = &url.URL{Scheme: "ws", Host: "localhost", Path: path, RawQuery: URL.RawQuery}
+	return socketPath, target.String(), nil
+}
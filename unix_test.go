@@ -0,0 +1,164 @@
+package gws
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixSocket(t *testing.T) {
+	var as = assert.New(t)
+	var dir = t.TempDir()
+
+	var newServer = func(option *ServerOption) (*Server, chan *Conn) {
+		var sockets = make(chan *Conn, 1)
+		var handler = new(webSocketMocker)
+		handler.onOpen = func(socket *Conn) { sockets <- socket }
+		handler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		return NewServer(handler, option), sockets
+	}
+
+	var echo = func(client *Conn) {
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		client.handler = &webSocketMocker{onMessage: func(socket *Conn, message *Message) {
+			as.Equal("hello", message.Data.String())
+			wg.Done()
+		}}
+		go client.ReadLoop()
+		as.NoError(client.WriteString("hello"))
+		wg.Wait()
+	}
+
+	t.Run("unix", func(t *testing.T) {
+		var path = filepath.Join(dir, "gws.sock")
+
+		// 残留的套接字文件会被删除. 监听器关闭后, 指向同一套接字的硬链接不再有监听者.
+		// The leftover socket file is removed. After the listener is closed, a hard link to its socket has no listener.
+		listener, err := net.Listen(networkUnix, filepath.Join(dir, "stale.sock"))
+		if !as.NoError(err) {
+			return
+		}
+		as.NoError(os.Link(filepath.Join(dir, "stale.sock"), path))
+		_ = listener.Close()
+
+		server, sockets := newServer(&ServerOption{UnixSocketMode: 0600})
+		go server.RunNetwork(networkUnix, path)
+		time.Sleep(100 * time.Millisecond)
+
+		info, err := os.Stat(path)
+		if as.NoError(err) {
+			as.Equal(os.FileMode(0600), info.Mode().Perm())
+		}
+
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws+unix://" + path + ":/chat?name=caspar"})
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		var socket = <-sockets
+		as.Equal("/chat", socket.Request().URL.Path)
+		as.Equal("caspar", socket.Request().URL.Query().Get("name"))
+		echo(client)
+
+		// 套接字仍在监听时不会被删除
+		// The socket file is not removed while it is still listening
+		as.Error(NewServer(new(BuiltinEventHandler), nil).RunNetwork(networkUnix, path))
+		as.Error(NewServer(new(BuiltinEventHandler), &ServerOption{UnixSocketMode: 0600}).RunNetwork(networkUnix, path))
+		_, err = os.Stat(path)
+		as.NoError(err)
+
+		// 创建套接字使用的临时目录已被删除
+		// The temporary directory used to create the socket has been removed
+		matches, _ := filepath.Glob(filepath.Join(dir, ".gws-*"))
+		as.Empty(matches)
+	})
+
+	t.Run("unixpacket", func(t *testing.T) {
+		var path = filepath.Join(dir, "gws-packet.sock")
+		server, sockets := newServer(&ServerOption{
+			ReadBufferSize:   512,
+			MaxHeaderBytes:   1024,
+			HibernateTimeout: 50 * time.Millisecond,
+		})
+		go server.RunNetwork(networkUnixPacket, path)
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial(networkUnixPacket, path)
+		if !as.NoError(err) {
+			return
+		}
+		client, _, err := NewClientFromConn(new(BuiltinEventHandler), &ClientOption{Addr: "ws+unix://" + path, ReadBufferSize: 512}, conn)
+		if !as.NoError(err) {
+			return
+		}
+		defer client.NetConn().Close()
+		as.Equal("/", (<-sockets).Request().URL.Path)
+
+		// 休眠之后读取大于读缓冲区的数据包, 数据包不会被截断
+		// Packets larger than the read buffer are read after hibernation without being truncated
+		var payload = internal.AlphabetNumeric.Generate(4096)
+		var messages = make(chan []byte, 1)
+		client.handler = &webSocketMocker{onMessage: func(socket *Conn, message *Message) {
+			messages <- message.Bytes()
+		}}
+		go client.ReadLoop()
+		time.Sleep(200 * time.Millisecond)
+		as.NoError(client.WriteMessage(OpcodeBinary, payload))
+		select {
+		case p := <-messages:
+			as.Equal(payload, p)
+		case <-time.After(time.Second):
+			as.Fail("timeout")
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		var server, _ = newServer(nil)
+		var addr = "127.0.0.1:" + nextPort()
+		go server.RunNetwork("tcp4", addr)
+		time.Sleep(100 * time.Millisecond)
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+		if as.NoError(err) {
+			_ = client.NetConn().Close()
+		}
+		as.Error(server.RunNetwork("none", addr))
+	})
+
+	t.Run("parse url", func(t *testing.T) {
+		var parse = func(s string) (string, string, error) {
+			u, _ := url.Parse(s)
+			return parseUnixURL(u)
+		}
+		socketPath, target, err := parse("ws+unix:///tmp/app.sock:/connect?id=1")
+		as.NoError(err)
+		as.Equal("/tmp/app.sock", socketPath)
+		as.Equal("ws://localhost/connect?id=1", target)
+
+		socketPath, target, err = parse("ws+unix://./app.sock")
+		as.NoError(err)
+		as.Equal("./app.sock", socketPath)
+		as.Equal("ws://localhost/", target)
+
+		_, _, err = parse("ws+unix://:/connect")
+		as.ErrorIs(err, ErrUnsupportedProtocol)
+
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws+unix://" + filepath.Join(dir, "none.sock")})
+		as.Error(err)
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws+unix:///tmp/app.sock", HTTP2Transport: &pipeTransport{}})
+		as.ErrorIs(err, ErrUnsupportedProtocol)
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "http://localhost"})
+		as.ErrorIs(err, ErrUnsupportedProtocol)
+		as.True(isAbstractSocket("@gws"))
+		as.NoError(removeStaleSocket(networkUnix, filepath.Join(dir, "none.sock")))
+	})
+}
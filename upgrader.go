@@ -14,6 +14,30 @@ import (
 	"github.com/lxzan/gws/internal"
 )
 
+// 拒绝响应中由升级器自行写入的头部字段
+// Header fields of the rejection response that are written by the upgrader itself
+var rejectionExcludeHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Date":              true,
+	"Transfer-Encoding": true,
+}
+
+// 握手响应中由升级器自行写入的头部字段, 不允许用户覆盖
+// Header fields of the handshake response that are written by the upgrader itself and cannot be overridden by the user
+var protectedHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Accept":     true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+	"Content-Length":           true,
+	"Transfer-Encoding":        true,
+}
+
+// 防止响应头注入
+// Prevents response header injection
+var headerValueReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
 type responseWriter struct {
 	// 错误信息
 	// Error information
@@ -54,24 +78,25 @@ func (c *responseWriter) WithHeader(k, v string) {
 	c.b.WriteString("\r\n")
 }
 
-// WithExtraHeader 添加额外的 HTTP Header
-// Adds extra http header
+// WithExtraHeader 添加额外的 HTTP Header, 写入全部的值, 受保护的和非法的字段会被忽略
+// Adds extra http header, all values are written, protected and invalid fields are ignored
 func (c *responseWriter) WithExtraHeader(h http.Header) {
-	for k, _ := range h {
-		c.WithHeader(k, h.Get(k))
+	for k, values := range h {
+		if !internal.IsToken(k) || protectedHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for _, v := range values {
+			c.WithHeader(k, headerValueReplacer.Replace(v))
+		}
 	}
 }
 
-// WithSubProtocol 根据请求头和预期的子协议列表设置子协议
-// Sets the subprotocol based on the request header and the expected subprotocols list
-func (c *responseWriter) WithSubProtocol(requestHeader http.Header, expectedSubProtocols []string) {
-	if len(expectedSubProtocols) > 0 {
-		c.subprotocol = internal.GetIntersectionElem(expectedSubProtocols, internal.Split(requestHeader.Get(internal.SecWebSocketProtocol.Key), ","))
-		if c.subprotocol == "" {
-			c.err = ErrSubprotocolNegotiation
-			return
-		}
-		c.WithHeader(internal.SecWebSocketProtocol.Key, c.subprotocol)
+// WithSubProtocol 设置协商的子协议
+// Sets the negotiated subprotocol
+func (c *responseWriter) WithSubProtocol(subprotocol string) {
+	c.subprotocol = subprotocol
+	if subprotocol != "" {
+		c.WithHeader(internal.SecWebSocketProtocol.Key, subprotocol)
 	}
 }
 
@@ -95,19 +120,35 @@ type Upgrader struct {
 	option       *ServerOption
 	deflaterPool *deflaterPool
 	eventHandler Event
+	extensions   []Extension
+	admission    *admission
 }
 
 // NewUpgrader 创建一个新的 Upgrader 实例
 // Creates a new instance of Upgrader
 func NewUpgrader(eventHandler Event, option *ServerOption) *Upgrader {
+	u := newUpgrader(eventHandler, initServerOption(option))
+	u.admission = newAdmission(u.option)
+	return u
+}
+
+// 使用初始化过的配置创建升级器
+// Creates an upgrader with initialized options
+func newUpgrader(eventHandler Event, option *ServerOption) *Upgrader {
 	u := &Upgrader{
-		option:       initServerOption(option),
+		option:       option,
 		eventHandler: eventHandler,
 		deflaterPool: new(deflaterPool),
 	}
 	if u.option.PermessageDeflate.Enabled {
-		u.deflaterPool.initialize(u.option.PermessageDeflate, option.ReadMaxPayloadSize)
+		u.deflaterPool.initialize(u.option.PermessageDeflate, u.option.ReadMaxPayloadSize)
+		u.extensions = append(u.extensions, newPermessageDeflateExtension(true, u.option.PermessageDeflate, u.deflaterPool, u.option.ReadMaxPayloadSize))
+	}
+	if u.option.PermessageZstd.Enabled {
+		var pool = newZstdPool(u.option.PermessageZstd, u.option.ReadMaxPayloadSize)
+		u.extensions = append(u.extensions, newPermessageZstdExtension(true, u.option.PermessageZstd, pool, u.option.ReadMaxPayloadSize))
 	}
+	u.extensions = append(u.extensions, u.option.Extensions...)
 	return u
 }
 
@@ -127,28 +168,15 @@ func (c *Upgrader) hijack(w http.ResponseWriter) (net.Conn, *bufio.Reader, error
 	return netConn, br, nil
 }
 
-// 根据客户端和服务器的扩展协商结果获取 PermessageDeflate 配置
-// Gets the PermessageDeflate configuration based on the negotiation results between the client and server extensions
-func (c *Upgrader) getPermessageDeflate(extensions string) PermessageDeflate {
-	clientPD := permessageNegotiation(extensions)
-	serverPD := c.option.PermessageDeflate
-	pd := PermessageDeflate{
-		Enabled:               serverPD.Enabled && strings.Contains(extensions, internal.PermessageDeflate),
-		Threshold:             serverPD.Threshold,
-		Level:                 serverPD.Level,
-		PoolSize:              serverPD.PoolSize,
-		ServerContextTakeover: clientPD.ServerContextTakeover && serverPD.ServerContextTakeover,
-		ClientContextTakeover: clientPD.ClientContextTakeover && serverPD.ClientContextTakeover,
-		ServerMaxWindowBits:   serverPD.ServerMaxWindowBits,
-		ClientMaxWindowBits:   serverPD.ClientMaxWindowBits,
-	}
-	pd.setThreshold(true)
-	return pd
-}
-
 // Upgrade 升级 HTTP 连接到 WebSocket 连接
+// HTTP/2 请求通过扩展 CONNECT (RFC 8441) 升级, 此时 Handler 返回后流就会关闭, 需要在 Handler 中阻塞调用 ReadLoop.
 // Upgrades the HTTP connection to a WebSocket connection
+// HTTP/2 requests are upgraded via extended CONNECT (RFC 8441), in which case the stream is closed after the Handler returns,
+// so ReadLoop must be called in the Handler in a blocking way.
 func (c *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if isHTTP2Request(r) {
+		return c.upgradeHTTP2(w, r)
+	}
 	netConn, br, err := c.hijack(w)
 	if err != nil {
 		return nil, err
@@ -160,105 +188,213 @@ func (c *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 // Upgrades from an existing network connection to a WebSocket connection
 func (c *Upgrader) UpgradeFromConn(conn net.Conn, br *bufio.Reader, r *http.Request) (*Conn, error) {
 	socket, err := c.doUpgradeFromConn(conn, br, r)
-	if err != nil {
+	if err != nil && !isUpgradedError(err) {
 		_ = c.writeErr(conn, err)
 		_ = conn.Close()
 	}
 	return socket, err
 }
 
-// 向客户端写入 HTTP 错误响应
-// Writes an HTTP error response to the client
+// 向客户端写入 HTTP 错误响应, *HandshakeError 按其状态码, 响应头和响应体写入, 其他错误使用 400
+// Writes an HTTP error response to the client, *HandshakeError is written with its status code, headers and body,
+// other errors use 400
 func (c *Upgrader) writeErr(conn net.Conn, err error) error {
-	var str = err.Error()
+	var e, status, body = toRejection(err)
 	var buf = binaryPool.Get(256)
-	buf.WriteString("HTTP/1.1 400 Bad Request\r\n")
+	buf.WriteString("HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n")
 	buf.WriteString("Date: " + time.Now().Format(time.RFC1123) + "\r\n")
-	buf.WriteString("Content-Length: " + strconv.Itoa(len(str)) + "\r\n")
-	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	if e.Header.Get("Content-Type") == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	}
+	_ = e.Header.WriteSubset(buf, rejectionExcludeHeaders)
 	buf.WriteString("\r\n")
-	buf.WriteString(str)
+	buf.Write(body)
 	_, result := buf.WriteTo(conn)
 	binaryPool.Put(buf)
 	return result
 }
 
-// 从现有的网络连接升级到 WebSocket 连接
-// Upgrades from an existing network connection to a WebSocket connection
-func (c *Upgrader) doUpgradeFromConn(netConn net.Conn, br *bufio.Reader, r *http.Request) (*Conn, error) {
-	// 授权请求，如果授权失败，返回未授权错误
-	// Authorize the request, if authorization fails, return an unauthorized error
-	var session = c.option.NewSession()
-	if !c.option.Authorize(r, session) {
-		return nil, ErrUnauthorized
+// 将握手错误转换为拒绝响应, 返回响应头所在的 *HandshakeError, 状态码和响应体
+// Converts a handshake error into a rejection response, returns the *HandshakeError holding the headers,
+// the status code and the body
+func toRejection(err error) (*HandshakeError, int, []byte) {
+	var e *HandshakeError
+	if !errors.As(err, &e) {
+		var status = internal.SelectValue(errors.Is(err, ErrOriginNotAllowed), http.StatusForbidden, http.StatusBadRequest)
+		e = &HandshakeError{StatusCode: status, Err: err}
 	}
-
-	// 检查请求头
-	// check request headers
-	if r.Method != http.MethodGet {
-		return nil, ErrHandshake
+	var status = e.StatusCode
+	if status < 300 || status > 599 || http.StatusText(status) == "" {
+		status = http.StatusBadRequest
 	}
-	if !strings.EqualFold(r.Header.Get(internal.SecWebSocketVersion.Key), internal.SecWebSocketVersion.Val) {
-		return nil, errors.New("gws: websocket version not supported")
+	var body = e.Body
+	if len(body) == 0 {
+		body = []byte(e.Error())
 	}
-	if !internal.HttpHeaderContains(r.Header.Get(internal.Connection.Key), internal.Connection.Val) {
-		return nil, ErrHandshake
+	return e, status, body
+}
+
+// 鉴权请求, 如果鉴权失败, 返回握手拒绝响应
+// Authorizes the request, returns a handshake rejection response if authorization fails
+func (c *Upgrader) authorize(r *http.Request, session SessionStorage) error {
+	if c.option.AuthorizeRequest == nil {
+		return internal.SelectValue[error](c.option.Authorize(r, session), nil, ErrUnauthorized)
 	}
-	if !strings.EqualFold(r.Header.Get(internal.Upgrade.Key), internal.Upgrade.Val) {
-		return nil, ErrHandshake
+	err := c.option.AuthorizeRequest(r, session)
+	if err == nil {
+		return nil
 	}
+	var e *HandshakeError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &HandshakeError{StatusCode: http.StatusUnauthorized, Err: err}
+}
 
-	var rw = new(responseWriter).Init()
-	defer rw.Close()
+// 握手协商的结果
+// Result of the handshake negotiation
+type negotiation struct {
+	// 拓展响应头
+	// Extensions response header
+	extensionHeader string
+
+	// 协商成功的拓展
+	// Negotiated extensions
+	extensions []negotiatedExtension
+
+	// 子协议
+	// Subprotocol
+	subprotocol string
+
+	// 连接级别的响应头
+	// Connection-level response headers
+	header http.Header
+}
 
-	var extensions = r.Header.Get(internal.SecWebSocketExtensions.Key)
-	var pd = c.getPermessageDeflate(extensions)
-	if pd.Enabled {
-		rw.WithHeader(internal.SecWebSocketExtensions.Key, pd.genResponseHeader())
+// 检查请求来源并鉴权, 返回连接的 session
+// Checks the origin of the request and authorizes it, returns the session of the connection
+func (c *Upgrader) checkRequest(r *http.Request) (SessionStorage, error) {
+	// 检查请求来源, 防御跨站 WebSocket 劫持
+	// Check the origin of the request to defend against cross-site WebSocket hijacking
+	if !c.option.CheckOrigin(r) {
+		return nil, ErrOriginNotAllowed
 	}
 
-	var websocketKey = r.Header.Get(internal.SecWebSocketKey.Key)
-	if websocketKey == "" {
-		return nil, ErrHandshake
+	// 授权请求，如果授权失败，返回未授权错误
+	// Authorize the request, if authorization fails, return an unauthorized error
+	var session = c.option.NewSession()
+	if err := c.authorize(r, session); err != nil {
+		return nil, err
 	}
-	rw.WithHeader(internal.SecWebSocketAccept.Key, internal.ComputeAcceptKey(websocketKey))
-	rw.WithSubProtocol(r.Header, c.option.SubProtocols)
-	rw.WithExtraHeader(c.option.ResponseHeader)
-	if err := rw.Write(netConn, c.option.HandshakeTimeout); err != nil {
+
+	if !strings.EqualFold(r.Header.Get(internal.SecWebSocketVersion.Key), internal.SecWebSocketVersion.Val) {
+		return nil, errors.New("gws: websocket version not supported")
+	}
+	return session, nil
+}
+
+// 协商拓展和子协议, 收集连接级别的响应头
+// Negotiates extensions and the subprotocol, collects connection-level response headers
+func (c *Upgrader) negotiate(r *http.Request, session SessionStorage) (*negotiation, error) {
+	var n = new(negotiation)
+	var offers = strings.Join(r.Header.Values(internal.SecWebSocketExtensions.Key), ", ")
+	n.extensionHeader, n.extensions = negotiateExtensions(offers, c.extensions)
+
+	var err error
+	if n.subprotocol, err = c.selectSubProtocol(r); err != nil {
 		return nil, err
 	}
+	if c.option.SetResponseHeader != nil {
+		n.header = http.Header{}
+		c.option.SetResponseHeader(r, session, n.header)
+	}
+	return n, nil
+}
 
-	config := c.option.getConfig()
+// 创建服务端连接
+// Creates a server-side connection
+func (c *Upgrader) newConn(netConn net.Conn, br *bufio.Reader, r *http.Request, session SessionStorage, n *negotiation) *Conn {
 	socket := &Conn{
 		ss:                session,
 		isServer:          true,
-		subprotocol:       rw.subprotocol,
-		pd:                pd,
+		subprotocol:       n.subprotocol,
+		request:           cloneRequest(r),
 		conn:              netConn,
-		config:            config,
+		config:            c.option.getConfig(),
 		br:                br,
 		continuationFrame: continuationFrame{},
 		fh:                frameHeader{},
-		handler:           c.eventHandler,
+		handler:           selectEventHandler(c.eventHandler, c.option.SubProtocolHandlers, n.subprotocol),
 		closed:            0,
 		writeQueue:        workerQueue{maxConcurrency: 1},
 		readQueue:         make(channel, c.option.ParallelGolimit),
 	}
+	socket.setExtensions(n.extensions)
+	return socket
+}
 
-	// 压缩字典和解压字典内存开销比较大, 故使用懒加载
-	// Compressing and decompressing dictionaries has a large memory overhead, so use lazy loading.
-	if pd.Enabled {
-		socket.deflater = c.deflaterPool.Select()
-		if pd.ServerContextTakeover {
-			socket.cpsWindow.initialize(config.cswPool, pd.ServerMaxWindowBits)
-		}
-		if pd.ClientContextTakeover {
-			socket.dpsWindow.initialize(config.dswPool, pd.ClientMaxWindowBits)
-		}
+// 从现有的网络连接升级到 WebSocket 连接, 准入控制在任何用户代码之前执行
+// Upgrades from an existing network connection to a WebSocket connection,
+// admission control is performed before any user code
+func (c *Upgrader) doUpgradeFromConn(netConn net.Conn, br *bufio.Reader, r *http.Request) (*Conn, error) {
+	var ip = remoteHost(netConn.RemoteAddr())
+	if err := c.admission.acquire(ip); err != nil {
+		return nil, c.rejectFromConn(netConn, r, err)
+	}
+	socket, err := c.handshakeFromConn(netConn, br, r)
+	if err != nil {
+		c.admission.release(ip)
+		return nil, err
 	}
+	socket.release = func() { c.admission.release(ip) }
 	return socket, nil
 }
 
+// 在现有的网络连接上完成 HTTP/1.1 握手
+// Completes the HTTP/1.1 handshake on an existing network connection
+func (c *Upgrader) handshakeFromConn(netConn net.Conn, br *bufio.Reader, r *http.Request) (*Conn, error) {
+	session, err := c.checkRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查请求头
+	// check request headers
+	if r.Method != http.MethodGet {
+		return nil, ErrHandshake
+	}
+	if !internal.HttpHeaderContains(r.Header.Get(internal.Connection.Key), internal.Connection.Val) {
+		return nil, ErrHandshake
+	}
+	if !strings.EqualFold(r.Header.Get(internal.Upgrade.Key), internal.Upgrade.Val) {
+		return nil, ErrHandshake
+	}
+	var websocketKey = r.Header.Get(internal.SecWebSocketKey.Key)
+	if websocketKey == "" {
+		return nil, ErrHandshake
+	}
+
+	n, err := c.negotiate(r, session)
+	if err != nil {
+		return nil, err
+	}
+
+	var rw = new(responseWriter).Init()
+	defer rw.Close()
+	if n.extensionHeader != "" {
+		rw.WithHeader(internal.SecWebSocketExtensions.Key, n.extensionHeader)
+	}
+	rw.WithHeader(internal.SecWebSocketAccept.Key, internal.ComputeAcceptKey(websocketKey))
+	rw.WithSubProtocol(n.subprotocol)
+	rw.WithExtraHeader(c.option.ResponseHeader)
+	rw.WithExtraHeader(n.header)
+	if err := rw.Write(netConn, c.option.HandshakeTimeout); err != nil {
+		return nil, err
+	}
+	return c.newConn(netConn, br, r, session, n), nil
+}
+
 // Server WebSocket服务器
 // Websocket server
 type Server struct {
@@ -270,8 +406,13 @@ type Server struct {
 	// Server option configuration
 	option *ServerOption
 
-	// 错误处理回调函数
-	// Error handling callback function
+	// 按优先级排序的路由
+	// Routes sorted by priority
+	routes []*route
+
+	// 错误处理回调函数, conn 不会为 nil. 接受连接失败和 HTTP/2 流的握手失败只记录日志.
+	// Error handling callback function, conn is never nil.
+	// Accept failures and handshake failures of HTTP/2 streams are only logged.
 	OnError func(conn net.Conn, err error)
 
 	// 请求处理回调函数
@@ -279,14 +420,21 @@ type Server struct {
 	OnRequest func(conn net.Conn, br *bufio.Reader, r *http.Request)
 }
 
-// NewServer 创建一个新的 WebSocket 服务器实例
-// Creates a new WebSocket server instance
+// NewServer 创建一个新的 WebSocket 服务器实例, 没有通过 Handle 注册路由时, eventHandler 处理所有连接
+// Creates a new WebSocket server instance, eventHandler handles all connections if no routes are registered via Handle
 func NewServer(eventHandler Event, option *ServerOption) *Server {
 	var c = &Server{upgrader: NewUpgrader(eventHandler, option)}
 	c.option = c.upgrader.option
 	c.OnError = func(conn net.Conn, err error) { c.option.Logger.Error("gws: " + err.Error()) }
 	c.OnRequest = func(conn net.Conn, br *bufio.Reader, r *http.Request) {
-		socket, err := c.GetUpgrader().UpgradeFromConn(conn, br, r)
+		upgrader, err := c.match(r)
+		if err != nil {
+			_ = c.upgrader.writeErr(conn, err)
+			_ = conn.Close()
+			c.OnError(conn, err)
+			return
+		}
+		socket, err := upgrader.UpgradeFromConn(conn, br, r)
 		if err != nil {
 			c.OnError(conn, err)
 		} else {
@@ -305,17 +453,39 @@ func (c *Server) GetUpgrader() *Upgrader {
 // Run 启动 WebSocket 服务器，监听指定地址
 // Starts the WebSocket server and listens on the specified address
 func (c *Server) Run(addr string) error {
-	listener, err := net.Listen("tcp", addr)
+	return c.RunNetwork("tcp", addr)
+}
+
+// RunNetwork 启动 WebSocket 服务器, 监听指定网络上的地址, 例如 tcp, tcp4, unix 和 unixpacket
+// 对于 Unix 域套接字, 残留的套接字文件会被删除, 文件权限由 ServerOption.UnixSocketMode 设置, 监听器关闭时删除套接字文件.
+// 注意: unixpacket 保留消息边界, 每次读取一个完整的数据包, 对端的单次写入不能超过 256KB.
+// Starts the WebSocket server and listens on the address of the specified network, such as tcp, tcp4, unix and unixpacket
+// For Unix domain sockets, the leftover socket file is removed, the file permissions are set by ServerOption.UnixSocketMode,
+// and the socket file is removed when the listener is closed.
+// Note: unixpacket preserves message boundaries and whole packets are read at a time,
+// so a single write of the peer must not exceed 256KB.
+func (c *Server) RunNetwork(network, addr string) error {
+	listeners, err := c.listen(network, addr)
 	if err != nil {
 		return err
 	}
-	return c.RunListener(listener)
+	return c.runListeners(listeners, nil)
 }
 
 // RunTLS 启动支持 TLS 的 WebSocket 服务器，监听指定地址
+// certFile 和 keyFile 是默认证书, 与 ServerOption.TlsCertificateFiles 一起按 SNI 选择, 证书文件变化后自动重新加载.
+// TlsConfig.GetCertificate 优先调用, 返回 nil 时再按 SNI 从证书文件和 TlsConfig.Certificates 中选择.
+// 设置 TlsConfig.ClientAuth 和 ClientCAs 可以开启 mTLS, 客户端身份可以通过 PeerIdentityFromRequest 和 Conn.PeerIdentity 获取.
 // Starts the WebSocket server with TLS support and listens on the specified address
+// certFile and keyFile are the default certificate, which is selected by SNI together with ServerOption.TlsCertificateFiles,
+// certificates are reloaded automatically after the files change.
+// TlsConfig.GetCertificate is called first, if it returns nil the certificate is selected by SNI
+// from the certificate files and then TlsConfig.Certificates.
+// mTLS can be enabled by setting TlsConfig.ClientAuth and ClientCAs, the client identity can be obtained
+// via PeerIdentityFromRequest and Conn.PeerIdentity.
 func (c *Server) RunTLS(addr string, certFile, keyFile string) error {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	var files = append([]CertificateFile{{CertFile: certFile, KeyFile: keyFile}}, c.option.TlsCertificateFiles...)
+	store, err := newCertificateStore(files, c.option.CertificateReloadInterval, c.option.Logger)
 	if err != nil {
 		return err
 	}
@@ -324,36 +494,98 @@ func (c *Server) RunTLS(addr string, certFile, keyFile string) error {
 		c.option.TlsConfig = &tls.Config{}
 	}
 	config := c.option.TlsConfig.Clone()
-	config.Certificates = []tls.Certificate{cert}
-	config.NextProtos = []string{"http/1.1"}
+	config.GetCertificate = store.withConfig(config.GetCertificate, config.Certificates)
+	config.Certificates = nil
+	config.NextProtos = []string{alpnHTTP11}
+	if c.option.HTTP2Enabled {
+		config.NextProtos = []string{alpnHTTP2, alpnHTTP11}
+	}
 
-	listener, err := net.Listen("tcp", addr)
+	listeners, err := c.listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	return c.RunListener(tls.NewListener(listener, config))
+	return c.runListeners(listeners, config)
 }
 
 // RunListener 使用指定的监听器运行 WebSocket 服务器
+// 接受连接遇到临时错误时退避重试, 监听器关闭后返回 nil.
 // Runs the WebSocket server using the specified listener
+// Temporary accept errors are retried with backoff, returns nil after the listener is closed.
 func (c *Server) RunListener(listener net.Listener) error {
+	return c.runListeners([]net.Listener{listener}, nil)
+}
+
+// 打开监听器, TCP 网络在 ServerOption.ReusePortListeners 大于 1 时打开多个 SO_REUSEPORT 监听器
+// Opens the listeners, multiple SO_REUSEPORT listeners are opened for TCP networks
+// if ServerOption.ReusePortListeners is greater than 1
+func (c *Server) listen(network, addr string) ([]net.Listener, error) {
+	if isUnixNetwork(network) {
+		listener, err := c.listenUnix(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+	if c.option.ReusePortListeners > 1 && strings.HasPrefix(network, "tcp") {
+		return listenReusePort(network, addr, c.option.ReusePortListeners)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
+
+// 运行服务器, 每个监听器使用独立的 goroutine 接受连接, 共享转交给 net/http 的监听器.
+// 所有监听器都关闭后返回; 任意一个监听器出错时关闭其他监听器并返回该错误.
+// config 不为空时在读取 PROXY 协议头部之后进行 TLS 握手.
+// Runs the server, each listener accepts connections in its own goroutine, sharing the listener handed over to net/http.
+// Returns after all listeners are closed; if any listener fails, the others are closed and the error is returned.
+// If config is not nil the TLS handshake is performed after the PROXY protocol header is read.
+func (c *Server) runListeners(listeners []net.Listener, config *tls.Config) error {
+	var handoff *handoffListener
+	if c.option.HTTP2Enabled || c.option.FallbackHandler != nil {
+		handoff = newHandoffListener(listeners[0].Addr())
+		defer handoff.Close()
+		go func() { _ = c.newHTTPServer().Serve(handoff) }()
+	}
+
+	var errs = make(chan error, len(listeners))
+	for _, item := range listeners {
+		go func(listener net.Listener) { errs <- c.acceptLoop(listener, config, handoff) }(item)
+	}
+	var result error
+	for range listeners {
+		if err := <-errs; err != nil && result == nil {
+			result = err
+			closeListeners(listeners)
+		}
+	}
+	return result
+}
+
+// 接受连接, 监听器关闭后返回 nil
+// Accepts connections, returns nil after the listener is closed
+func (c *Server) acceptLoop(listener net.Listener, config *tls.Config, handoff *handoffListener) error {
 	defer listener.Close()
 
+	var delay time.Duration
 	for {
 		netConn, err := listener.Accept()
 		if err != nil {
-			c.OnError(netConn, err)
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !isTemporary(err) {
+				return err
+			}
+			c.option.Logger.Error("gws: accept: " + err.Error())
+			delay = nextAcceptDelay(delay)
+			time.Sleep(delay)
 			continue
 		}
-
-		go func(conn net.Conn) {
-			br := c.option.config.brPool.Get()
-			br.Reset(conn)
-			if r, err := http.ReadRequest(br); err != nil {
-				c.OnError(conn, err)
-			} else {
-				c.OnRequest(conn, br, r)
-			}
-		}(netConn)
+		delay = 0
+		go c.serveConn(netConn, config, handoff)
 	}
 }
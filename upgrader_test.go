@@ -403,7 +403,7 @@ func TestSubprotocol(t *testing.T) {
 		assert.Error(t, err)
 	})
 
-	t.Run("client close", func(t *testing.T) {
+	t.Run("server without subprotocol", func(t *testing.T) {
 		var addr = "127.0.0.1:" + nextPort()
 		app := NewServer(new(BuiltinEventHandler), &ServerOption{})
 		go func() { app.Run(addr) }()
@@ -411,11 +411,15 @@ func TestSubprotocol(t *testing.T) {
 		time.Sleep(100 * time.Millisecond)
 		rh := http.Header{}
 		rh.Set("Sec-WebSocket-Protocol", "chat")
-		_, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+		client, _, err := NewClient(new(BuiltinEventHandler), &ClientOption{
 			Addr:          "ws://" + addr,
 			RequestHeader: rh,
 		})
-		assert.Error(t, err)
+		// 服务端不支持子协议时接受连接, 不返回 Sec-WebSocket-Protocol (RFC 6455 4.1)
+		// The server accepts the connection without Sec-WebSocket-Protocol when it supports no subprotocol (RFC 6455 4.1)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "", client.SubProtocol())
+		}
 	})
 
 	t.Run("ok", func(t *testing.T) {
@@ -432,6 +436,114 @@ func TestSubprotocol(t *testing.T) {
 		})
 		assert.NoError(t, err)
 	})
+
+	t.Run("select", func(t *testing.T) {
+		var addr = "127.0.0.1:" + nextPort()
+		var sockets = make(chan *Conn, 1)
+		var chatHandler = new(webSocketMocker)
+		chatHandler.onOpen = func(socket *Conn) { sockets <- socket }
+		app := NewServer(new(BuiltinEventHandler), &ServerOption{
+			SelectSubProtocol: func(r *http.Request, offered []string) (string, error) {
+				for _, item := range offered {
+					if item == "v2.chat" || item == "v1.chat" {
+						return item, nil
+					}
+				}
+				if internal.InCollection("bad", offered) {
+					return "", NewHandshakeError(http.StatusForbidden, "bad subprotocol")
+				}
+				if internal.InCollection("unknown", offered) {
+					return "unknown-1", nil
+				}
+				return "", nil
+			},
+			SubProtocolHandlers: map[string]Event{"v2.chat": chatHandler},
+		})
+		go func() { app.Run(addr) }()
+		time.Sleep(100 * time.Millisecond)
+
+		client, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:         "ws://" + addr,
+			SubProtocols: []string{"v2.chat", "v1.chat"},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "v2.chat", client.SubProtocol())
+		assert.Equal(t, "v2.chat", resp.Header.Get("Sec-WebSocket-Protocol"))
+		assert.Equal(t, "v2.chat", (<-sockets).SubProtocol())
+
+		// 多个头部行
+		// Multiple header lines
+		var rh = http.Header{}
+		rh.Add("Sec-WebSocket-Protocol", "mqtt")
+		rh.Add("Sec-WebSocket-Protocol", "v1.chat")
+		client, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr, RequestHeader: rh})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "v1.chat", client.SubProtocol())
+		}
+
+		// 服务端接受连接但不使用子协议
+		// The server accepts the connection without a subprotocol
+		client, resp, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "", client.SubProtocol())
+			assert.Empty(t, resp.Header.Values("Sec-WebSocket-Protocol"))
+		}
+
+		// 客户端提议了子协议, 服务端不选择任何一个
+		// The client offers subprotocols and the server selects none of them
+		client, resp, err = NewClient(new(BuiltinEventHandler), &ClientOption{
+			Addr:         "ws://" + addr,
+			SubProtocols: []string{"v3.chat", "mqtt"},
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "", client.SubProtocol())
+			assert.Empty(t, resp.Header.Values("Sec-WebSocket-Protocol"))
+		}
+
+		_, resp, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr, SubProtocols: []string{"bad"}})
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		_, _, err = NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr, SubProtocols: []string{"unknown"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("client confirm", func(t *testing.T) {
+		var newConnector = func(subprotocols ...string) *connector {
+			return &connector{option: initClientOption(&ClientOption{SubProtocols: subprotocols})}
+		}
+		var newResponse = func(values ...string) *http.Response {
+			var resp = &http.Response{Header: http.Header{}}
+			for _, v := range values {
+				resp.Header.Add("Sec-WebSocket-Protocol", v)
+			}
+			return resp
+		}
+
+		subprotocol, err := newConnector("a", "b").getSubProtocol(newResponse("b"))
+		assert.NoError(t, err)
+		assert.Equal(t, "b", subprotocol)
+		subprotocol, err = newConnector().getSubProtocol(newResponse())
+		assert.NoError(t, err)
+		assert.Equal(t, "", subprotocol)
+
+		_, err = newConnector("a", "b").getSubProtocol(newResponse("a", "b"))
+		assert.ErrorIs(t, err, ErrSubprotocolNegotiation)
+		_, err = newConnector("a", "b").getSubProtocol(newResponse("a, b"))
+		assert.ErrorIs(t, err, ErrSubprotocolNegotiation)
+		_, err = newConnector("a").getSubProtocol(newResponse("c"))
+		assert.ErrorIs(t, err, ErrSubprotocolNegotiation)
+		_, err = newConnector().getSubProtocol(newResponse("c"))
+		assert.ErrorIs(t, err, ErrSubprotocolNegotiation)
+
+		// 服务端可以不选择子协议
+		// The server may select no subprotocol
+		subprotocol, err = newConnector("a").getSubProtocol(newResponse())
+		assert.NoError(t, err)
+		assert.Equal(t, "", subprotocol)
+	})
 }
 
 func TestResponseWriter_Write(t *testing.T) {
@@ -461,3 +573,175 @@ func TestServer_RunListener(t *testing.T) {
 	}()
 	time.Sleep(time.Microsecond)
 }
+
+func TestCheckOrigin(t *testing.T) {
+	var as = assert.New(t)
+
+	var newRequest = func(host, origin string) *http.Request {
+		var request = &http.Request{Header: http.Header{}, Method: http.MethodGet, Host: host}
+		if origin != "" {
+			request.Header.Set("Origin", origin)
+		}
+		return request
+	}
+
+	t.Run("same origin", func(t *testing.T) {
+		as.True(checkSameOrigin(newRequest("example.com", "")))
+		as.True(checkSameOrigin(newRequest("example.com", "https://example.com")))
+		as.True(checkSameOrigin(newRequest("Example.com:8080", "http://example.COM:8080")))
+		as.False(checkSameOrigin(newRequest("example.com", "https://example.com:8080")))
+		as.False(checkSameOrigin(newRequest("example.com", "https://evil.com")))
+		as.False(checkSameOrigin(newRequest("example.com", "null")))
+	})
+
+	t.Run("allow origins", func(t *testing.T) {
+		var check = AllowOrigins("https://example.com", "*.example.org", "localhost:3000")
+		as.True(check(newRequest("ws.example.com", "")))
+		as.True(check(newRequest("ws.example.com", "https://example.com")))
+		as.True(check(newRequest("ws.example.com", "https://Example.com:8443")))
+		as.False(check(newRequest("ws.example.com", "http://example.com")))
+		as.False(check(newRequest("ws.example.com", "https://www.example.com")))
+		as.True(check(newRequest("ws.example.com", "https://a.example.org")))
+		as.True(check(newRequest("ws.example.com", "http://a.b.example.org")))
+		as.False(check(newRequest("ws.example.com", "https://example.org")))
+		as.False(check(newRequest("ws.example.com", "https://evilexample.org")))
+		as.True(check(newRequest("ws.example.com", "http://localhost:3000")))
+		as.False(check(newRequest("ws.example.com", "http://localhost:3001")))
+		as.False(check(newRequest("ws.example.com", "null")))
+		as.True(AllowOrigins("*")(newRequest("ws.example.com", "https://any.com")))
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		var upgrader = NewUpgrader(new(BuiltinEventHandler), nil)
+		var request = newRequest("example.com", "https://evil.com")
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", "3tTS/Y+YGaM7TTnPuafHng==")
+
+		server, client := net.Pipe()
+		go func() {
+			_, err := upgrader.UpgradeFromConn(server, bufio.NewReader(server), request)
+			as.ErrorIs(err, ErrOriginNotAllowed)
+		}()
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal(http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestHandshakeRejection(t *testing.T) {
+	var as = assert.New(t)
+
+	var reject = func(option *ServerOption) *http.Response {
+		var upgrader = NewUpgrader(new(BuiltinEventHandler), option)
+		var request = &http.Request{Header: http.Header{}, Method: http.MethodGet}
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", "3tTS/Y+YGaM7TTnPuafHng==")
+
+		server, client := net.Pipe()
+		go func() {
+			_, err := upgrader.UpgradeFromConn(server, bufio.NewReader(server), request)
+			as.Error(err)
+		}()
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		as.NoError(err)
+		return resp
+	}
+
+	var readBody = func(resp *http.Response) string {
+		var buf = bytes.NewBuffer(nil)
+		_, _ = buf.ReadFrom(resp.Body)
+		return buf.String()
+	}
+
+	t.Run("custom", func(t *testing.T) {
+		var resp = reject(&ServerOption{
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				var e = NewHandshakeError(http.StatusTooManyRequests, `{"code":"rate_limited"}`)
+				e.Header.Set("Retry-After", "30")
+				e.Header.Set("Content-Type", "application/json")
+				e.Header.Set("X-Injected", "a\r\nSet-Cookie: x=1")
+				return e
+			},
+		})
+		as.Equal(http.StatusTooManyRequests, resp.StatusCode)
+		as.Equal("30", resp.Header.Get("Retry-After"))
+		as.Equal("application/json", resp.Header.Get("Content-Type"))
+		as.Empty(resp.Header.Get("Set-Cookie"))
+		as.Equal(`{"code":"rate_limited"}`, readBody(resp))
+	})
+
+	t.Run("plain error", func(t *testing.T) {
+		var resp = reject(&ServerOption{
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				return errors.New("token expired")
+			},
+		})
+		as.Equal(http.StatusUnauthorized, resp.StatusCode)
+		as.Equal("token expired", readBody(resp))
+	})
+
+	t.Run("invalid status", func(t *testing.T) {
+		var resp = reject(&ServerOption{
+			AuthorizeRequest: func(r *http.Request, session SessionStorage) error {
+				return &HandshakeError{StatusCode: http.StatusOK}
+			},
+		})
+		as.Equal(http.StatusBadRequest, resp.StatusCode)
+		as.Equal("gws: handshake rejected, status=200", readBody(resp))
+	})
+
+	t.Run("authorize", func(t *testing.T) {
+		var resp = reject(&ServerOption{
+			Authorize: func(r *http.Request, session SessionStorage) bool { return false },
+		})
+		as.Equal(http.StatusBadRequest, resp.StatusCode)
+		as.Equal(ErrUnauthorized.Error(), readBody(resp))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var err error = &HandshakeError{StatusCode: http.StatusForbidden, Err: ErrUnauthorized}
+		as.ErrorIs(err, ErrUnauthorized)
+		as.Equal(ErrUnauthorized.Error(), err.Error())
+	})
+}
+
+func TestSetResponseHeader(t *testing.T) {
+	var as = assert.New(t)
+	var addr = "127.0.0.1:" + nextPort()
+	var server = NewServer(new(BuiltinEventHandler), &ServerOption{
+		ResponseHeader: http.Header{"X-Region": []string{"us-east", "us-west"}},
+		Authorize: func(r *http.Request, session SessionStorage) bool {
+			session.Store("id", "10086")
+			return true
+		},
+		SetResponseHeader: func(r *http.Request, session SessionStorage, header http.Header) {
+			id, _ := session.Load("id")
+			header.Set("X-Client-Id", id.(string))
+			header.Add("Set-Cookie", (&http.Cookie{Name: "sid", Value: "abc", HttpOnly: true}).String())
+			header.Add("Set-Cookie", (&http.Cookie{Name: "lang", Value: "en"}).String())
+			header.Set("Sec-WebSocket-Accept", "xxx")
+			header["upgrade"] = []string{"h2c"}
+			header.Set("X-Injected", "a\r\nX-Evil: 1")
+		},
+	})
+	go server.Run(addr)
+	time.Sleep(100 * time.Millisecond)
+
+	client, resp, err := NewClient(new(BuiltinEventHandler), &ClientOption{Addr: "ws://" + addr})
+	if !as.NoError(err) {
+		return
+	}
+	defer client.NetConn().Close()
+	as.Equal("10086", resp.Header.Get("X-Client-Id"))
+	as.ElementsMatch([]string{"us-east", "us-west"}, resp.Header.Values("X-Region"))
+	as.Equal(2, len(resp.Cookies()))
+	as.Equal([]string{"websocket"}, resp.Header.Values("Upgrade"))
+	as.Equal("a  X-Evil: 1", resp.Header.Get("X-Injected"))
+	as.Empty(resp.Header.Get("X-Evil"))
+}
@@ -14,12 +14,15 @@ import (
 const segmentSize = 128 * 1024
 
 // 获取大文件压缩器
+// 客户端复用连接的压缩器, 使用期间持有锁, 防止被休眠释放.
 // Get bigDeflater
+// The client reuses the compressor of the connection and holds the lock while using it to prevent it from being released by hibernation.
 func (c *Conn) getBigDeflater() *bigDeflater {
 	if c.isServer {
 		return c.config.bdPool.Get()
 	}
-	return (*bigDeflater)(c.deflater.cpsWriter)
+	c.deflater.cpsLocker.Lock()
+	return (*bigDeflater)(c.deflater.writer())
 }
 
 // 回收大文件压缩器
@@ -27,7 +30,9 @@ func (c *Conn) getBigDeflater() *bigDeflater {
 func (c *Conn) putBigDeflater(d *bigDeflater) {
 	if c.isServer {
 		c.config.bdPool.Put(d)
+		return
 	}
+	c.deflater.cpsLocker.Unlock()
 }
 
 // 拆分io.Reader为小切片
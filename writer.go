@@ -126,7 +126,7 @@ func (c *Conn) doWrite(opcode Opcode, payload internal.Payload) error {
 	// For context_takeover mode to work correctly, the contexts of compression, writing, and dictionary updating must be synchronized.
 	frame, err := c.genFrame(opcode, payload, frameConfig{
 		fin:           true,
-		compress:      c.pd.Enabled,
+		compress:      len(c.extensions) > 0,
 		broadcast:     false,
 		checkEncoding: c.config.CheckUtf8Enabled,
 	})
@@ -146,8 +146,8 @@ type frameConfig struct {
 	// Finish flag
 	fin bool
 
-	// 是否开启压缩
-	// Whether to enable compression
+	// 是否使用协商的拓展编码(压缩)
+	// Whether to encode (compress) with the negotiated extensions
 	compress bool
 
 	// 帧生成动作是否由广播发起
@@ -173,21 +173,44 @@ func (c *Conn) genFrame(opcode Opcode, payload internal.Payload, cfg frameConfig
 	var buf = binaryPool.Get(n + frameHeaderSize)
 	buf.Write(framePadding[0:])
 
-	if cfg.compress && opcode.isDataFrame() && n >= c.pd.Threshold {
-		return c.compressData(opcode, payload, buf, cfg)
+	if cfg.compress && opcode.isDataFrame() {
+		// 广播帧由多个连接共享, 只能使用无字典的 permessage-deflate
+		// Broadcast frames are shared by multiple connections, only dictionary-free permessage-deflate can be used
+		if cfg.broadcast {
+			if c.pd.Enabled && n >= c.pd.Threshold {
+				return c.compressData(opcode, payload, buf, cfg)
+			}
+		} else {
+			rsv, err := c.encodeMessage(opcode, payload, buf)
+			if err != nil {
+				binaryPool.Put(buf)
+				return nil, err
+			}
+			if rsv != 0 {
+				return c.fillFrameHeader(buf, opcode, cfg.fin, rsv), nil
+			}
+		}
 	}
 
-	var header = frameHeader{}
-	headerLength, maskBytes := header.GenerateHeader(c.isServer, cfg.fin, false, opcode, n)
 	_, _ = payload.WriteTo(buf)
+	return c.fillFrameHeader(buf, opcode, cfg.fin, 0), nil
+}
+
+// 填充帧头, buf 的前 frameHeaderSize 个字节为预留的帧头空间
+// Fills in the frame header, the first frameHeaderSize bytes of buf are reserved for the frame header
+func (c *Conn) fillFrameHeader(buf *bytes.Buffer, opcode Opcode, fin bool, rsv uint8) *bytes.Buffer {
 	var contents = buf.Bytes()
+	var payloadSize = buf.Len() - frameHeaderSize
+	var header = frameHeader{}
+	headerLength, maskBytes := header.GenerateHeader(c.isServer, fin, false, opcode, payloadSize)
+	header[0] |= rsv
 	if !c.isServer {
 		internal.MaskXOR(contents[frameHeaderSize:], maskBytes)
 	}
 	var m = frameHeaderSize - headerLength
 	copy(contents[m:], header[:headerLength])
 	buf.Next(m)
-	return buf, nil
+	return buf
 }
 
 // 压缩数据并生成帧
@@ -199,18 +222,7 @@ func (c *Conn) compressData(opcode Opcode, payload internal.Payload, buf *bytes.
 	if err := c.deflater.Compress(payload, buf, dict); err != nil {
 		return nil, err
 	}
-
-	var contents = buf.Bytes()
-	var payloadSize = buf.Len() - frameHeaderSize
-	var header = frameHeader{}
-	headerLength, maskBytes := header.GenerateHeader(c.isServer, cfg.fin, true, opcode, payloadSize)
-	if !c.isServer {
-		internal.MaskXOR(contents[frameHeaderSize:], maskBytes)
-	}
-	var m = frameHeaderSize - headerLength
-	copy(contents[m:], header[:headerLength])
-	buf.Next(m)
-	return buf, nil
+	return c.fillFrameHeader(buf, opcode, cfg.fin, RSV1), nil
 }
 
 type (
@@ -305,3 +317,132 @@ func (c *Broadcaster) Close() error {
 	}
 	return nil
 }
+
+type (
+	// PreparedMessage 预编码消息
+	// 与 Broadcaster 不同, PreparedMessage 可以在整个生命周期内反复发送给任意连接, 不需要关闭.
+	// 每种压缩变体只会编码一次, 适合缓存快照等需要多次发送的内容.
+	// Prepared message
+	// Unlike Broadcaster, a PreparedMessage can be sent to any connection repeatedly over its lifetime, no need to close it.
+	// Each compression variant is encoded only once, suitable for content that is sent many times, such as cached snapshots.
+	PreparedMessage struct {
+		opcode   Opcode
+		payload  []byte
+		once     sync.Once
+		validUtf bool
+
+		// 下标 0 是未压缩的变体, 压缩变体按照压缩器的滑动窗口区分, 下标为 windowBits-7,
+		// 以免发送超出对端窗口的回溯距离.
+		// Index 0 is the uncompressed variant, compressed variants are distinguished by the sliding window
+		// of the compressor at index windowBits-7, so that back-references beyond the window of the peer are never sent.
+		frames [9]preparedFrame
+	}
+
+	preparedFrame struct {
+		once sync.Once
+		err  error
+
+		// 消息内容, 压缩变体存放的是压缩后的数据
+		// Message payload, the compressed variant holds the compressed data
+		payload []byte
+
+		// 服务端角色的完整帧, 无需掩码, 可以直接写入连接
+		// Complete frame for the server role, no mask required, can be written to the connection directly
+		frame []byte
+	}
+)
+
+// NewPreparedMessage 创建预编码消息
+// Creates a prepared message
+// 创建后不要修改 payload 的内容.
+// Do not modify the contents of payload after creation.
+func NewPreparedMessage(opcode Opcode, payload []byte) *PreparedMessage {
+	return &PreparedMessage{opcode: opcode, payload: payload}
+}
+
+// 检查文本编码, 结果会被缓存
+// Checks the text encoding, the result is cached
+func (c *PreparedMessage) checkEncoding(enabled bool) bool {
+	if !enabled || c.opcode != OpcodeText {
+		return true
+	}
+	c.once.Do(func() { c.validUtf = internal.CheckEncoding(true, uint8(c.opcode), c.payload) })
+	return c.validUtf
+}
+
+// 获取连接对应的编码变体
+// Gets the encoded variant corresponding to the connection
+func (c *PreparedMessage) getFrame(socket *Conn) (*preparedFrame, bool, error) {
+	if !c.checkEncoding(socket.config.CheckUtf8Enabled) {
+		return nil, false, ErrTextEncoding
+	}
+	if len(c.payload) > socket.config.WriteMaxPayloadSize {
+		return nil, false, ErrMessageTooLarge
+	}
+
+	var compressed = socket.pd.Enabled && c.opcode.isDataFrame() && len(c.payload) >= socket.pd.Threshold
+	var frame = &c.frames[0]
+	if compressed {
+		frame = &c.frames[socket.deflater.windowBits-7]
+	}
+	frame.once.Do(func() {
+		frame.payload = c.payload
+		if compressed {
+			// 和广播一样, 不能使用字典, 保证每个连接收到的都是相同的内容
+			// As with broadcasting, the dictionary cannot be used, to ensure that every connection receives the same content.
+			var buf = bytes.NewBuffer(nil)
+			if frame.err = socket.deflater.Compress(internal.Bytes(c.payload), buf, nil); frame.err != nil {
+				return
+			}
+			frame.payload = buf.Bytes()
+		}
+
+		var header = frameHeader{}
+		var n = len(frame.payload)
+		headerLength, _ := header.GenerateHeader(true, true, compressed, c.opcode, n)
+		frame.frame = make([]byte, 0, headerLength+n)
+		frame.frame = append(frame.frame, header[:headerLength]...)
+		frame.frame = append(frame.frame, frame.payload...)
+	})
+	return frame, compressed, frame.err
+}
+
+// WritePreparedMessage 写入预编码消息
+// Writes a prepared message
+func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
+	err := c.doWritePrepared(pm)
+	c.emitError(false, err)
+	return err
+}
+
+func (c *Conn) doWritePrepared(pm *PreparedMessage) error {
+	frame, compressed, err := pm.getFrame(c)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+
+	if c.isServer {
+		err = internal.WriteN(c.conn, frame.frame)
+	} else {
+		// 客户端的每一帧都必须使用新的掩码
+		// Every client frame must use a new mask
+		var header = frameHeader{}
+		var n = len(frame.payload)
+		headerLength, maskBytes := header.GenerateHeader(false, true, compressed, pm.opcode, n)
+		var buf = binaryPool.Get(headerLength + n)
+		buf.Write(header[:headerLength])
+		buf.Write(frame.payload)
+		internal.MaskXOR(buf.Bytes()[headerLength:], maskBytes)
+		err = internal.WriteN(c.conn, buf.Bytes())
+		binaryPool.Put(buf)
+	}
+	_, _ = c.cpsWindow.Write(pm.payload)
+	return err
+}
@@ -3,6 +3,7 @@ package gws
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"io"
 	"net"
@@ -394,6 +395,100 @@ func TestNewBroadcaster(t *testing.T) {
 	})
 }
 
+func TestPreparedMessage(t *testing.T) {
+	var as = assert.New(t)
+
+	t.Run("server to client", func(t *testing.T) {
+		for _, compress := range []bool{false, true} {
+			var serverHandler = new(webSocketMocker)
+			var clientHandler = new(webSocketMocker)
+			var serverOption = &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: compress, Threshold: 1}}
+			var clientOption = &ClientOption{PermessageDeflate: PermessageDeflate{Enabled: compress}}
+			server, client := newPeer(serverHandler, serverOption, clientHandler, clientOption)
+
+			var payload = internal.AlphabetNumeric.Generate(1024)
+			var count = 8
+			var wg = &sync.WaitGroup{}
+			wg.Add(count)
+			clientHandler.onMessage = func(socket *Conn, message *Message) {
+				as.Equal(string(payload), message.Data.String())
+				wg.Done()
+			}
+			go server.ReadLoop()
+			go client.ReadLoop()
+
+			var pm = NewPreparedMessage(OpcodeText, payload)
+			for i := 0; i < count; i++ {
+				as.NoError(server.WritePreparedMessage(pm))
+			}
+			wg.Wait()
+			as.Equal(compress, pm.frames[8].frame != nil)
+		}
+	})
+
+	t.Run("window bits", func(t *testing.T) {
+		// 协商了不同滑动窗口的连接使用不同的压缩变体
+		// Connections that negotiated different sliding windows use different compressed variants
+		var block = make([]byte, 4*1024)
+		_, _ = rand.Read(block)
+		var payload = bytes.Repeat(block, 4)
+		var pm = NewPreparedMessage(OpcodeBinary, payload)
+		for _, windowBits := range []int{15, 10} {
+			var clientHandler = new(webSocketMocker)
+			var serverOption = &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1, ServerMaxWindowBits: windowBits}}
+			var clientOption = &ClientOption{PermessageDeflate: PermessageDeflate{Enabled: true, ServerMaxWindowBits: windowBits}}
+			server, client := newPeer(new(webSocketMocker), serverOption, clientHandler, clientOption)
+			var messages = make(chan []byte, 1)
+			clientHandler.onMessage = func(socket *Conn, message *Message) { messages <- message.Bytes() }
+			go server.ReadLoop()
+			go client.ReadLoop()
+			as.NoError(server.WritePreparedMessage(pm))
+			as.Equal(payload, <-messages)
+		}
+
+		var frame = &pm.frames[10-7]
+		output, err := inflateWindow(append(append([]byte{}, frame.payload...), 0x00, 0x00, 0xff, 0xff), 10)
+		as.NoError(err)
+		as.Equal(payload, output)
+		as.NotNil(pm.frames[15-7].frame)
+	})
+
+	t.Run("client to server", func(t *testing.T) {
+		var serverHandler = new(webSocketMocker)
+		var clientHandler = new(webSocketMocker)
+		var serverOption = &ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1}}
+		var clientOption = &ClientOption{PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1}}
+		server, client := newPeer(serverHandler, serverOption, clientHandler, clientOption)
+
+		var payload = internal.AlphabetNumeric.Generate(1024)
+		var count = 8
+		var wg = &sync.WaitGroup{}
+		wg.Add(count)
+		serverHandler.onMessage = func(socket *Conn, message *Message) {
+			as.Equal(string(payload), message.Data.String())
+			wg.Done()
+		}
+		go server.ReadLoop()
+		go client.ReadLoop()
+
+		var pm = NewPreparedMessage(OpcodeBinary, payload)
+		for i := 0; i < count; i++ {
+			as.NoError(client.WritePreparedMessage(pm))
+		}
+		wg.Wait()
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		var serverOption = &ServerOption{CheckUtf8Enabled: true, WriteMaxPayloadSize: 16}
+		server, client := newPeer(new(webSocketMocker), serverOption, new(webSocketMocker), &ClientOption{})
+		go client.ReadLoop()
+
+		as.ErrorIs(server.WritePreparedMessage(NewPreparedMessage(OpcodeBinary, make([]byte, 32))), ErrMessageTooLarge)
+		as.ErrorIs(server.WritePreparedMessage(NewPreparedMessage(OpcodeText, []byte{0xff})), ErrTextEncoding)
+		as.ErrorIs(server.WritePreparedMessage(NewPreparedMessage(OpcodeText, []byte("a"))), ErrConnClosed)
+	})
+}
+
 type broadcastHandler struct {
 	BuiltinEventHandler
 	wg      *sync.WaitGroup
@@ -0,0 +1,265 @@
+package gws
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lxzan/gws/internal"
+)
+
+const (
+	// permessage-zstd 拓展名称
+	// permessage-zstd extension name
+	permessageZstd = "permessage-zstd"
+
+	// 字典 ID 参数
+	// Dictionary ID parameter
+	zstdDictID = "dict_id"
+
+	// zstd 滑动窗口大小, 解压时也以此作为窗口上限
+	// zstd sliding window size, also used as the window limit when decompressing
+	zstdWindowSize = 256 * 1024
+)
+
+// LoadZstdDictionary 从文件加载 zstd 字典, 字典由 zstd --train 生成
+// Loads a zstd dictionary from a file, the dictionary is generated by zstd --train
+func LoadZstdDictionary(filename string) ([]byte, error) {
+	dict, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zstd.InspectDictionary(dict); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// 获取字典 ID, 没有字典时返回 0
+// Gets the dictionary ID, returns 0 if there is no dictionary
+func zstdDictionaryID(dict []byte) uint32 {
+	if len(dict) == 0 {
+		return 0
+	}
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0
+	}
+	return d.ID()
+}
+
+// zstd 压缩器, 编码器和解码器都是懒加载的
+// zstd compressor, both the encoder and the decoder are lazily loaded
+type zstdCodec struct {
+	option    PermessageZstd
+	limit     int
+	cpsLocker sync.Mutex
+	encoders  [2]*zstd.Encoder
+	dpsLocker sync.Mutex
+	decoder   *zstd.Decoder
+	dpsBuffer *bytes.Buffer
+	buf       []byte
+}
+
+func newZstdCodec(option PermessageZstd, limit int) *zstdCodec {
+	return &zstdCodec{option: option, limit: limit}
+}
+
+// 获取编码器, 调用者需要持有 cpsLocker
+// Gets the encoder, the caller must hold cpsLocker
+func (c *zstdCodec) encoder(useDict bool) (*zstd.Encoder, error) {
+	var idx = internal.SelectValue(useDict, 1, 0)
+	if c.encoders[idx] != nil {
+		return c.encoders[idx], nil
+	}
+	var options = []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.option.Level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithEncoderCRC(false),
+		zstd.WithWindowSize(zstdWindowSize),
+	}
+	if useDict {
+		options = append(options, zstd.WithEncoderDict(c.option.Dictionary))
+	}
+	enc, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	c.encoders[idx] = enc
+	return enc, nil
+}
+
+// Compress 压缩
+// Compress data
+func (c *zstdCodec) Compress(src io.WriterTo, dst *bytes.Buffer, useDict bool) error {
+	c.cpsLocker.Lock()
+	defer c.cpsLocker.Unlock()
+
+	enc, err := c.encoder(useDict)
+	if err != nil {
+		return err
+	}
+	enc.Reset(dst)
+	if _, err = src.WriteTo(enc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Decompress 解压
+// 输出超过 limit 时中止解压并返回 limitErr, limit 不能放宽自身的长度限制.
+// Decompress data
+// Decompression is aborted and limitErr is returned when the output exceeds limit,
+// limit cannot relax its own length limit.
+func (c *zstdCodec) Decompress(src *bytes.Buffer, limit int, limitErr error) (*bytes.Buffer, error) {
+	c.dpsLocker.Lock()
+	defer c.dpsLocker.Unlock()
+
+	if c.decoder == nil {
+		var options = []zstd.DOption{
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(zstdWindowSize),
+			zstd.WithDecoderMaxMemory(uint64(c.limit)),
+		}
+		if len(c.option.Dictionary) > 0 {
+			options = append(options, zstd.WithDecoderDicts(c.option.Dictionary))
+		}
+		dec, err := zstd.NewReader(nil, options...)
+		if err != nil {
+			return nil, err
+		}
+		c.decoder, c.dpsBuffer, c.buf = dec, bytes.NewBuffer(nil), make([]byte, 32*1024)
+	}
+
+	if limit > c.limit {
+		limit, limitErr = c.limit, internal.CloseMessageTooLarge
+	}
+	if c.dpsBuffer.Cap() > int(bufferThreshold) {
+		c.dpsBuffer = bytes.NewBuffer(nil)
+	}
+	c.dpsBuffer.Reset()
+	if err := c.decoder.Reset(src); err != nil {
+		return nil, err
+	}
+	reader := limitReader(c.decoder, limit, limitErr)
+	if _, err := io.CopyBuffer(c.dpsBuffer, reader, c.buf); err != nil {
+		return nil, err
+	}
+	var dst = binaryPool.Get(c.dpsBuffer.Len())
+	_, _ = c.dpsBuffer.WriteTo(dst)
+	return dst, nil
+}
+
+// 基于拓展框架实现的 permessage-zstd
+// permessage-zstd implemented on top of the extension framework
+type permessageZstdExtension struct {
+	isServer bool
+	option   PermessageZstd
+	dictID   uint32
+	pool     *compressorPool[*zstdCodec]
+	limit    int
+}
+
+// 创建 permessage-zstd 拓展, 服务端的压缩器来自共享的压缩器池
+// Creates the permessage-zstd extension, the server-side compressors come from a shared pool
+func newPermessageZstdExtension(isServer bool, option PermessageZstd, pool *compressorPool[*zstdCodec], limit int) *permessageZstdExtension {
+	return &permessageZstdExtension{
+		isServer: isServer,
+		option:   option,
+		dictID:   zstdDictionaryID(option.Dictionary),
+		pool:     pool,
+		limit:    limit,
+	}
+}
+
+// 创建 zstd 压缩器池
+// Creates a zstd compressor pool
+func newZstdPool(option PermessageZstd, limit int) *compressorPool[*zstdCodec] {
+	var pool = new(compressorPool[*zstdCodec])
+	pool.initialize(option.PoolSize, func() *zstdCodec { return newZstdCodec(option, limit) })
+	return pool
+}
+
+func (c *permessageZstdExtension) Name() string { return permessageZstd }
+
+func (c *permessageZstdExtension) RSV() uint8 { return RSV1 }
+
+func (c *permessageZstdExtension) Offer() []ExtensionParam {
+	if c.dictID == 0 {
+		return nil
+	}
+	return []ExtensionParam{{Key: zstdDictID, Value: strconv.FormatUint(uint64(c.dictID), 10)}}
+}
+
+// 解析字典 ID 参数, 不认识的参数, 重复的参数, 非法的值都会返回错误
+// Parses the dictionary ID parameter, unknown parameters, duplicate parameters and invalid values all return an error
+func parseZstdParams(params []ExtensionParam) (uint32, error) {
+	var dictID uint32
+	for _, param := range params {
+		if param.Key != zstdDictID || dictID != 0 {
+			return 0, ErrExtensionNegotiation
+		}
+		n, err := strconv.ParseUint(param.Value, 10, 32)
+		if err != nil || n == 0 {
+			return 0, ErrExtensionNegotiation
+		}
+		dictID = uint32(n)
+	}
+	return dictID, nil
+}
+
+func (c *permessageZstdExtension) Accept(offer []ExtensionParam) ([]ExtensionParam, ExtensionHandler, error) {
+	dictID, err := parseZstdParams(offer)
+	if err != nil {
+		return nil, nil, err
+	}
+	var handler = &zstdHandler{ext: c, codec: c.pool.Select()}
+	if dictID == 0 || dictID != c.dictID {
+		return nil, handler, nil
+	}
+	handler.useDict = true
+	return offer, handler, nil
+}
+
+func (c *permessageZstdExtension) Confirm(response []ExtensionParam) (ExtensionHandler, error) {
+	dictID, err := parseZstdParams(response)
+	if err != nil {
+		return nil, err
+	}
+	if dictID != 0 && dictID != c.dictID {
+		return nil, ErrExtensionNegotiation
+	}
+	return &zstdHandler{ext: c, codec: newZstdCodec(c.option, c.limit), useDict: dictID != 0}, nil
+}
+
+// permessage-zstd 连接级别的处理器
+// Connection-level handler of permessage-zstd
+type zstdHandler struct {
+	ext     *permessageZstdExtension
+	codec   *zstdCodec
+	useDict bool
+}
+
+func (c *zstdHandler) Encode(socket *Conn, opcode Opcode, payload ExtensionPayload, dst *bytes.Buffer) (bool, error) {
+	if payload.Len() < c.ext.option.Threshold {
+		return false, nil
+	}
+	if err := c.codec.Compress(payload, dst, c.useDict); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *zstdHandler) Decode(socket *Conn, opcode Opcode, payload *bytes.Buffer) (*bytes.Buffer, error) {
+	limit, limitErr := socket.decompressLimit(payload.Len())
+	dst, err := c.codec.Decompress(payload, limit, limitErr)
+	if err != nil {
+		return nil, err
+	}
+	socket.budget.consume(dst.Len())
+	return dst, nil
+}
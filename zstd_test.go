@@ -0,0 +1,170 @@
+package gws
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lxzan/gws/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// 生成测试用的 JSON 消息
+// Generate JSON messages for testing
+func newZstdSample(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","tags":["alpha","beta","gamma"],"profile":{"city":"shanghai","score":%d,"token":"%s"}}`,
+		i, i, i*7, internal.AlphabetNumeric.Generate(16)))
+}
+
+func newZstdDictionary(t *testing.T, id uint32) []byte {
+	var contents [][]byte
+	for i := 0; i < 1000; i++ {
+		contents = append(contents, newZstdSample(i))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  bytes.Repeat(newZstdSample(-1), 8),
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedDefault,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict
+}
+
+func TestPermessageZstd(t *testing.T) {
+	var as = assert.New(t)
+
+	var echo = func(serverOption *ServerOption, clientOption *ClientOption, extension string) {
+		var addr = "127.0.0.1:" + nextPort()
+		var serverHandler = new(webSocketMocker)
+		serverHandler.onMessage = func(socket *Conn, message *Message) {
+			_ = socket.WriteMessage(message.Opcode, message.Bytes())
+		}
+		var server = NewServer(serverHandler, serverOption)
+		go server.Run(addr)
+		time.Sleep(100 * time.Millisecond)
+
+		var wg = &sync.WaitGroup{}
+		var messages = make(chan string, 2)
+		var clientHandler = new(webSocketMocker)
+		clientHandler.onMessage = func(socket *Conn, message *Message) {
+			messages <- message.Data.String()
+			wg.Done()
+		}
+		clientOption.Addr = "ws://" + addr
+		client, resp, err := NewClient(clientHandler, clientOption)
+		if !as.NoError(err) {
+			return
+		}
+		as.Equal(extension, resp.Header.Get(internal.SecWebSocketExtensions.Key))
+		as.Equal(RSV1, client.rsvMask)
+		go client.ReadLoop()
+
+		var payload = newZstdSample(1)
+		wg.Add(2)
+		as.NoError(client.WriteMessage(OpcodeText, payload))
+		as.NoError(client.WriteString("a"))
+		wg.Wait()
+		as.Equal(string(payload), <-messages)
+		as.Equal("a", <-messages)
+	}
+
+	t.Run("prefer zstd", func(t *testing.T) {
+		echo(
+			&ServerOption{
+				PermessageDeflate: PermessageDeflate{Enabled: true},
+				PermessageZstd:    PermessageZstd{Enabled: true, Threshold: 1},
+			},
+			&ClientOption{
+				PermessageDeflate: PermessageDeflate{Enabled: true},
+				PermessageZstd:    PermessageZstd{Enabled: true, Threshold: 1},
+			},
+			"permessage-zstd",
+		)
+	})
+
+	t.Run("fallback to deflate", func(t *testing.T) {
+		echo(
+			&ServerOption{
+				PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+				PermessageZstd:    PermessageZstd{Enabled: true},
+			},
+			&ClientOption{PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1}},
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		)
+		echo(
+			&ServerOption{PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1}},
+			&ClientOption{
+				PermessageDeflate: PermessageDeflate{Enabled: true, Threshold: 1},
+				PermessageZstd:    PermessageZstd{Enabled: true},
+			},
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		)
+	})
+
+	t.Run("dictionary", func(t *testing.T) {
+		var filename = filepath.Join(t.TempDir(), "dict")
+		as.NoError(os.WriteFile(filename, newZstdDictionary(t, 1024), 0644))
+		dict, err := LoadZstdDictionary(filename)
+		if !as.NoError(err) {
+			return
+		}
+		echo(
+			&ServerOption{PermessageZstd: PermessageZstd{Enabled: true, Threshold: 1, Dictionary: dict}},
+			&ClientOption{PermessageZstd: PermessageZstd{Enabled: true, Threshold: 1, Dictionary: dict}},
+			"permessage-zstd; dict_id=1024",
+		)
+
+		// 字典 ID 不一致时不使用字典
+		// The dictionary is not used if the dictionary IDs are different
+		echo(
+			&ServerOption{PermessageZstd: PermessageZstd{Enabled: true, Threshold: 1, Dictionary: newZstdDictionary(t, 2048)}},
+			&ClientOption{PermessageZstd: PermessageZstd{Enabled: true, Threshold: 1, Dictionary: dict}},
+			"permessage-zstd",
+		)
+
+		_, err = LoadZstdDictionary(filepath.Join(t.TempDir(), "none"))
+		as.Error(err)
+		as.NoError(os.WriteFile(filename, []byte("hello"), 0644))
+		_, err = LoadZstdDictionary(filename)
+		as.Error(err)
+	})
+
+	t.Run("negotiation", func(t *testing.T) {
+		var ext = newPermessageZstdExtension(false, PermessageZstd{Enabled: true}, nil, defaultReadMaxPayloadSize)
+		_, err := confirmExtensions("permessage-zstd; dict_id=1", []Extension{ext})
+		as.ErrorIs(err, ErrExtensionNegotiation)
+		_, err = confirmExtensions("permessage-zstd; level=1", []Extension{ext})
+		as.ErrorIs(err, ErrExtensionNegotiation)
+
+		var upgrader = NewUpgrader(new(BuiltinEventHandler), &ServerOption{PermessageZstd: PermessageZstd{Enabled: true}})
+		header, results := negotiateExtensions("permessage-zstd; dict_id=x, permessage-zstd; dict_id=7", upgrader.extensions)
+		as.Equal("permessage-zstd", header)
+		as.False(results[0].handler.(*zstdHandler).useDict)
+	})
+
+	t.Run("decompression limit", func(t *testing.T) {
+		var codec = newZstdCodec(PermessageZstd{Level: defaultZstdLevel}, 1024*1024)
+		var src = binaryPool.Get(1024)
+		as.NoError(codec.Compress(internal.Bytes(make([]byte, 64*1024)), src, false))
+		var n = src.Len()
+
+		_, err := codec.Decompress(bytes.NewBuffer(src.Bytes()), 10*n, ErrDecompressionRatio)
+		as.ErrorIs(err, ErrDecompressionRatio)
+
+		dst, err := codec.Decompress(bytes.NewBuffer(src.Bytes()), 1024*1024, ErrDecompressionRatio)
+		as.NoError(err)
+		as.Equal(64*1024, dst.Len())
+
+		_, err = codec.Decompress(bytes.NewBuffer(src.Bytes()[:n/2]), 1024*1024, ErrDecompressionRatio)
+		as.Error(err)
+	})
+}